@@ -0,0 +1,310 @@
+/*
+auth.go
+authentication of requests: basic, bearer and refreshing tokens
+*/
+
+package esme
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+
+	// defaultTokenEarly tokens are renewed this long before they expire
+	defaultTokenEarly = time.Minute
+
+	// tokenTimeout timeout of a token request
+	tokenTimeout = 30 * time.Second
+)
+
+// Auth set the credentials of a request
+//	Apply runs before every attempt of the request
+type Auth interface {
+	Apply(req *http.Request) error
+}
+
+// Refresher an Auth whose credentials can be renewed
+//	when a response is 401, Invalidate is called with the request,
+//	the request is sent once more when it returns true
+type Refresher interface {
+	Auth
+	Invalidate(req *http.Request) bool
+}
+
+// BasicAuth http basic authentication
+type BasicAuth struct {
+	Username string
+
+	Password string
+}
+
+// BearerAuth a static bearer token
+type BearerAuth struct {
+	Token string
+}
+
+// Token an access token
+type Token struct {
+
+	// AccessToken the token sent in the Authorization header
+	AccessToken string `json:"access_token"`
+
+	// TokenType type of the token, "Bearer" when empty
+	TokenType string `json:"token_type"`
+
+	// Expiry when the token expires, zero when it does not expire
+	Expiry time.Time `json:"expiry"`
+}
+
+// TokenFunc returns a new token, like a login or an oauth2 token request
+type TokenFunc func(ctx context.Context) (*Token, error)
+
+// RefreshAuth a token renewed before it expires and after a 401
+//	safe for concurrent use, concurrent requests share one renewal
+type RefreshAuth struct {
+
+	// Fetch returns a new token
+	Fetch TokenFunc
+
+	// Early renew the token this long before it expires, 1 minute by default
+	Early time.Duration
+
+	mux sync.Mutex
+
+	token *Token
+}
+
+// ClientCredentials oauth2 client credentials grant (RFC 6749 4.4)
+type ClientCredentials struct {
+
+	// TokenURL url of the token endpoint
+	TokenURL string
+
+	ClientID string
+
+	ClientSecret string
+
+	Scopes []string
+
+	// Params other parameters of the token request
+	Params map[string]string
+
+	// Client client of the token request, by default the transport of the esme request
+	//	needing the token (proxy, tls and resolver), http.DefaultClient otherwise
+	Client *http.Client
+}
+
+// Apply set the basic authorization header
+func (a *BasicAuth) Apply(req *http.Request) error {
+	req.SetBasicAuth(a.Username, a.Password)
+	return nil
+}
+
+// Apply set the bearer authorization header
+func (a *BearerAuth) Apply(req *http.Request) error {
+	req.Header.Set("Authorization", "Bearer "+a.Token)
+	return nil
+}
+
+// NewRefreshAuth returns a *RefreshAuth getting its tokens from fetch
+func NewRefreshAuth(fetch TokenFunc) *RefreshAuth {
+	return &RefreshAuth{Fetch: fetch}
+}
+
+// NewClientCredentialsAuth returns a *RefreshAuth of the oauth2 client credentials grant
+func NewClientCredentialsAuth(tokenURL, clientID, clientSecret string, scopes ...string) *RefreshAuth {
+	c := &ClientCredentials{
+		TokenURL:     tokenURL,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Scopes:       scopes,
+	}
+	return NewRefreshAuth(c.Fetch)
+}
+
+// Token returns a valid token, fetched when there is none or it expires soon
+func (a *RefreshAuth) Token(ctx context.Context) (*Token, error) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	if a.token != nil && !a.expired(a.token) {
+		return a.token, nil
+	}
+	if a.Fetch == nil {
+		return nil, errors.New("RefreshAuth.Fetch is nil")
+	}
+	token, err := a.Fetch(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("fetch token: %s", err.Error())
+	}
+	if token == nil || token.AccessToken == "" {
+		return nil, errors.New("fetch token: empty access token")
+	}
+	a.token = token
+	return token, nil
+}
+
+// Apply set the authorization header with a valid token
+func (a *RefreshAuth) Apply(req *http.Request) error {
+	token, err := a.Token(req.Context())
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", token.header())
+	return nil
+}
+
+// Invalidate drop the token sent by req, the next Apply fetches a new one
+//	returns false when req was sent without a token
+func (a *RefreshAuth) Invalidate(req *http.Request) bool {
+	sent := req.Header.Get("Authorization")
+	if sent == "" {
+		return false
+	}
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	// another request may have renewed the token already
+	if a.token != nil && a.token.header() == sent {
+		a.token = nil
+	}
+	return true
+}
+
+// Fetch request a token from the token endpoint
+func (c *ClientCredentials) Fetch(ctx context.Context) (*Token, error) {
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	if len(c.Scopes) > 0 {
+		form.Set("scope", strings.Join(c.Scopes, " "))
+	}
+	for k, v := range c.Params {
+		form.Set(k, v)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, tokenTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(c.ClientID), url.QueryEscape(c.ClientSecret))
+
+	resp, err := c.client(ctx).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &StatusError{
+			Method:     req.Method,
+			URL:        c.TokenURL,
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			Header:     resp.Header,
+			Body:       body,
+		}
+	}
+
+	var result struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err = json.Unmarshal(body, &result); err != nil {
+		return nil, err
+	}
+	token := &Token{AccessToken: result.AccessToken, TokenType: result.TokenType}
+	if result.ExpiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(result.ExpiresIn) * time.Second)
+	}
+	return token, nil
+}
+
+// SetAuth set the authentication of the request
+//	applied before every attempt, a Refresher renews its credentials and retries once on 401
+func (c *Context) SetAuth(auth Auth) *Context {
+	if auth == nil {
+		return c
+	}
+	c.auth = auth
+	return c
+}
+
+/*
+private
+*/
+
+// header returns the value of the Authorization header
+func (t *Token) header() string {
+	if t.TokenType == "" || strings.EqualFold(t.TokenType, "bearer") {
+		return "Bearer " + t.AccessToken
+	}
+	return t.TokenType + " " + t.AccessToken
+}
+
+// expired returns whether token expires within Early
+func (a *RefreshAuth) expired(token *Token) bool {
+	if token.Expiry.IsZero() {
+		return false
+	}
+	early := a.Early
+	if early <= 0 {
+		early = defaultTokenEarly
+	}
+	return time.Now().Add(early).After(token.Expiry)
+}
+
+// client returns the client of the token request
+func (c *ClientCredentials) client(ctx context.Context) *http.Client {
+	if c.Client != nil {
+		return c.Client
+	}
+	if ec, _ := ctx.Value(contextKey{}).(*Context); ec != nil && ec.client.Transport != nil {
+		return &http.Client{Transport: ec.client.Transport}
+	}
+	return http.DefaultClient
+}
+
+// authRequest returns the request of c carrying c in its context
+//	the header is shared with c.Request, so Apply sets the header of c.Request
+func authRequest(c *Context) *http.Request {
+	return c.Request.WithContext(context.WithValue(c.Request.Context(), contextKey{}, c))
+}
+
+// authHandler apply auth before next, and retry once on 401
+func authHandler(auth Auth, next Handler) Handler {
+	return func(c *Context) error {
+		if err := auth.Apply(authRequest(c)); err != nil {
+			return newRequestError(ErrorAuth, err)
+		}
+		err := next(c)
+		if err != nil || c.Response == nil || c.Response.StatusCode != http.StatusUnauthorized {
+			return err
+		}
+		refresher, ok := auth.(Refresher)
+		if !ok || !refresher.Invalidate(c.Request) {
+			return nil
+		}
+		if err = auth.Apply(authRequest(c)); err != nil {
+			return newRequestError(ErrorAuth, err)
+		}
+		c.clearResponse()
+		return next(c)
+	}
+}
@@ -0,0 +1,185 @@
+package esme
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_BasicBearerAuth(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Header.Get("Authorization")))
+	}))
+	defer ts.Close()
+
+	ctx := HttpGet(ts.URL).SetAuth(&BasicAuth{Username: "esme", Password: "pass"})
+	if err := ctx.Do(); err != nil || ctx.ToString() != "Basic ZXNtZTpwYXNz" {
+		t.Fatalf("err %v body %s", err, ctx.ToString())
+	}
+	ctx = HttpGet(ts.URL).SetAuth(&BearerAuth{Token: "abc"})
+	if err := ctx.Do(); err != nil || ctx.ToString() != "Bearer abc" {
+		t.Fatalf("err %v body %s", err, ctx.ToString())
+	}
+}
+
+func Test_RefreshAuth(t *testing.T) {
+	var (
+		mux     sync.Mutex
+		valid   string
+		fetched int32
+		served  int
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.Lock()
+		defer mux.Unlock()
+		if r.Header.Get("Authorization") != "Bearer "+valid {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		// the token is revoked after 10 requests
+		if served++; served%10 == 0 {
+			valid = ""
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	auth := NewRefreshAuth(func(ctx context.Context) (*Token, error) {
+		n := atomic.AddInt32(&fetched, 1)
+		mux.Lock()
+		valid = fmt.Sprintf("token-%d", n)
+		mux.Unlock()
+		return &Token{AccessToken: valid, TokenType: "bearer"}, nil
+	})
+
+	queue := NewMemQueue()
+	for i := 0; i < 25; i++ {
+		queue.Add(&Task{Url: ts.URL, Method: "GET"})
+	}
+	job := NewJob("auth", 5, queue, JobOptions{Auth: auth})
+	job.Do()
+	if stats := job.Stats(); stats.Succeed != 25 {
+		t.Fatalf("stats %s", stats)
+	}
+	if n := atomic.LoadInt32(&fetched); n < 3 || n > 5 {
+		t.Fatalf("fetched %d", n)
+	}
+
+	// expiring tokens are renewed early
+	expiring := NewRefreshAuth(func(ctx context.Context) (*Token, error) {
+		return &Token{AccessToken: "t", Expiry: time.Now().Add(30 * time.Second)}, nil
+	})
+	first, _ := expiring.Token(context.Background())
+	second, _ := expiring.Token(context.Background())
+	if first == second {
+		t.Fatal("token expiring within a minute not renewed")
+	}
+
+	err := HttpGet(ts.URL).SetAuth(NewRefreshAuth(func(ctx context.Context) (*Token, error) {
+		return nil, errors.New("login failed")
+	})).Do()
+	var reqErr *RequestError
+	if !errors.As(err, &reqErr) || reqErr.Kind != ErrorAuth {
+		t.Fatalf("err %v", err)
+	}
+}
+
+func Test_ClientCredentials(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			id, secret, _ := r.BasicAuth()
+			if id != "client" || secret != "s3cret" || r.FormValue("grant_type") != "client_credentials" || r.FormValue("scope") != "read write" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			_, _ = w.Write([]byte(`{"access_token":"cc-token","token_type":"Bearer","expires_in":3600}`))
+		default:
+			_, _ = w.Write([]byte(r.Header.Get("Authorization")))
+		}
+	}))
+	defer ts.Close()
+
+	auth := NewClientCredentialsAuth(ts.URL+"/token", "client", "s3cret", "read", "write")
+	ctx := HttpGet(ts.URL + "/api").SetAuth(auth)
+	if err := ctx.Do(); err != nil || ctx.ToString() != "Bearer cc-token" {
+		t.Fatalf("err %v body %s", err, ctx.ToString())
+	}
+	token, _ := auth.Token(context.Background())
+	if time.Until(token.Expiry) < 59*time.Minute {
+		t.Fatalf("expiry %v", token.Expiry)
+	}
+
+	_, err := (&ClientCredentials{TokenURL: ts.URL + "/token", ClientID: "client"}).Fetch(context.Background())
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("err %v", err)
+	}
+}
+
+func Test_ClientCredentialsTransport(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			_, _ = w.Write([]byte(`{"access_token":"cc-token","expires_in":3600}`))
+			return
+		}
+		_, _ = w.Write([]byte(r.Header.Get("Authorization")))
+	}))
+	defer ts.Close()
+	u, _ := url.Parse(ts.URL)
+
+	// the token host only resolves with the resolver of the request
+	resolver := &Resolver{Hosts: map[string]string{"auth.esme.test": "127.0.0.1"}}
+	auth := NewClientCredentialsAuth("http://auth.esme.test:"+u.Port()+"/token", "client", "s3cret")
+	ctx := HttpGet(ts.URL + "/api").SetResolver(resolver).SetAuth(auth)
+	if err := ctx.Do(); err != nil || ctx.ToString() != "Bearer cc-token" {
+		t.Fatalf("err %v body %s", err, ctx.ToString())
+	}
+
+	var used bool
+	cc := &ClientCredentials{
+		TokenURL: ts.URL + "/token",
+		Client: &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			used = true
+			return http.DefaultTransport.RoundTrip(req)
+		})},
+	}
+	if token, err := cc.Fetch(context.Background()); err != nil || token.AccessToken != "cc-token" || !used {
+		t.Fatalf("err %v token %v used %v", err, token, used)
+	}
+}
+
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func Test_RefreshAuthClearsResponse(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) == 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte("expired"))
+			return
+		}
+		// the request with the new token fails without a response
+		panic(http.ErrAbortHandler)
+	}))
+	defer ts.Close()
+
+	auth := NewRefreshAuth(func(ctx context.Context) (*Token, error) {
+		return &Token{AccessToken: "token"}, nil
+	})
+	ctx := HttpGet(ts.URL).SetAuth(auth)
+	if err := ctx.Do(); err == nil || ctx.Response != nil || ctx.RespBody != nil || atomic.LoadInt32(&hits) < 2 {
+		t.Fatalf("err %v response %v body %s hits %d", err, ctx.Response, ctx.RespBody, atomic.LoadInt32(&hits))
+	}
+}
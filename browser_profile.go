@@ -0,0 +1,374 @@
+/*
+browser_profile.go
+browser profiles: user-agent with the matching headers of a browser
+*/
+
+package esme
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+
+	// DeviceDesktop desktop computer
+	DeviceDesktop = "desktop"
+
+	// DeviceMobile mobile phone
+	DeviceMobile = "mobile"
+
+	// DeviceTablet tablet
+	DeviceTablet = "tablet"
+)
+
+// ProfileRotation how often a job changes the browser profile
+type ProfileRotation string
+
+const (
+
+	// RotatePerRequest a random profile for every request
+	RotatePerRequest ProfileRotation = "request"
+
+	// RotatePerJob one random profile for all the requests of the job
+	RotatePerJob ProfileRotation = "job"
+
+	// RotatePerSession one random profile for the tasks of a session (Task.Session),
+	//	tasks without a session get a random profile
+	RotatePerSession ProfileRotation = "session"
+)
+
+// BrowserProfile user-agent and headers sent by a browser on an os and a device
+//	the headers are those of a top level navigation, Accept-Encoding is left to net/http.
+//	net/http sends headers in alphabetical order, the order of the browser is not kept
+type BrowserProfile struct {
+
+	// Name name of the profile, like "chrome-windows"
+	Name string `json:"name"`
+
+	// Browser like "chrome", "firefox", "safari", "edge"
+	Browser string `json:"browser"`
+
+	// OS like "windows", "macos", "linux", "android", "ios"
+	OS string `json:"os"`
+
+	// Device DeviceDesktop, DeviceMobile or DeviceTablet
+	Device string `json:"device"`
+
+	// UserAgent User-Agent header
+	UserAgent string `json:"user_agent"`
+
+	// Header other headers, like Accept, Accept-Language, Sec-CH-UA, Sec-Fetch-*
+	Header Header `json:"header"`
+
+	// TLSProfile name of the TLSProfile used when the request has no TLSOptions
+	TLSProfile string `json:"tls_profile,omitempty"`
+
+	// Weight weight of random choices, 1 when <= 0
+	Weight int `json:"weight,omitempty"`
+
+	tlsOnce sync.Once
+
+	tlsOptions *TLSOptions
+}
+
+// BrowserProfiles a set of browser profiles, reloadable from a json file
+type BrowserProfiles struct {
+	mux sync.RWMutex
+
+	profiles []*BrowserProfile
+}
+
+var (
+
+	// randMux guard random
+	randMux sync.Mutex
+
+	// random random source of profiles and user-agents, seeded once
+	random = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// NewBrowserProfiles returns *BrowserProfiles of profiles
+func NewBrowserProfiles(profiles ...*BrowserProfile) *BrowserProfiles {
+	b := &BrowserProfiles{}
+	for _, item := range profiles {
+		if item != nil && item.UserAgent != "" {
+			b.profiles = append(b.profiles, item)
+		}
+	}
+	return b
+}
+
+// DefaultBrowserProfiles returns the built-in profiles
+func DefaultBrowserProfiles() *BrowserProfiles {
+	return NewBrowserProfiles(defaultBrowserProfiles()...)
+}
+
+// LoadBrowserProfiles returns the profiles of a json file
+//	the file is a json array of BrowserProfile
+func LoadBrowserProfiles(file string) (*BrowserProfiles, error) {
+	b := &BrowserProfiles{}
+	if err := b.Load(file); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Load replace the profiles with those of a json file,
+//	used to refresh the user-agents of a running job
+func (b *BrowserProfiles) Load(file string) error {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var profiles []*BrowserProfile
+	if err = json.Unmarshal(data, &profiles); err != nil {
+		return fmt.Errorf("parse browser profiles %s: %s", file, err.Error())
+	}
+	loaded := NewBrowserProfiles(profiles...)
+	if len(loaded.profiles) == 0 {
+		return fmt.Errorf("no browser profile with a user_agent in %s", file)
+	}
+	b.mux.Lock()
+	b.profiles = loaded.profiles
+	b.mux.Unlock()
+	return nil
+}
+
+// Profiles returns all the profiles
+func (b *BrowserProfiles) Profiles() []*BrowserProfile {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	return append([]*BrowserProfile(nil), b.profiles...)
+}
+
+// Get returns the profile of name, nil when it does not exist
+func (b *BrowserProfiles) Get(name string) *BrowserProfile {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	for _, item := range b.profiles {
+		if item.Name == name {
+			return item
+		}
+	}
+	return nil
+}
+
+// Filter returns the profiles matching browser, os and device, an empty value matches all
+//	like: profiles.Filter("chrome", "", DeviceDesktop)
+func (b *BrowserProfiles) Filter(browser, os, device string) *BrowserProfiles {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	result := &BrowserProfiles{}
+	for _, item := range b.profiles {
+		if (browser == "" || item.Browser == browser) && (os == "" || item.OS == os) && (device == "" || item.Device == device) {
+			result.profiles = append(result.profiles, item)
+		}
+	}
+	return result
+}
+
+// Random returns a random profile chosen by weight, nil when there is none
+func (b *BrowserProfiles) Random() *BrowserProfile {
+	b.mux.RLock()
+	defer b.mux.RUnlock()
+	if len(b.profiles) == 0 {
+		return nil
+	}
+	total := 0
+	for _, item := range b.profiles {
+		total += item.weight()
+	}
+	n := randIntn(total)
+	for _, item := range b.profiles {
+		if n -= item.weight(); n < 0 {
+			return item
+		}
+	}
+	return b.profiles[len(b.profiles)-1]
+}
+
+// Apply set the headers of the profile missing in header,
+//	the User-Agent is replaced when it is empty or the default one of esme
+func (p *BrowserProfile) Apply(header http.Header) {
+	if ua := header.Get("User-Agent"); ua == "" || ua == defaultUserAgent {
+		header.Set("User-Agent", p.UserAgent)
+	}
+	for key, value := range p.Header {
+		if header.Get(key) == "" {
+			header.Set(key, value)
+		}
+	}
+}
+
+// SetBrowserProfile send the headers of a browser profile
+//	headers set before are kept, the TLSProfile of the profile is used when no TLSOptions is set
+func (c *Context) SetBrowserProfile(p *BrowserProfile) *Context {
+	if p == nil {
+		return c
+	}
+	c.profile = p
+	p.Apply(c.Request.Header)
+	if c.tls == nil && p.TLSProfile != "" {
+		c.SetTLS(p.tls())
+	}
+	return c
+}
+
+// BrowserProfile returns the browser profile of the request, nil when none is set
+func (c *Context) BrowserProfile() *BrowserProfile {
+	return c.profile
+}
+
+/*
+private
+*/
+
+func (p *BrowserProfile) weight() int {
+	if p.Weight <= 0 {
+		return 1
+	}
+	return p.Weight
+}
+
+// tls returns the TLSOptions of the TLSProfile, shared by the requests of the profile
+func (p *BrowserProfile) tls() *TLSOptions {
+	p.tlsOnce.Do(func() {
+		p.tlsOptions = &TLSOptions{Profile: p.TLSProfile}
+	})
+	return p.tlsOptions
+}
+
+// randIntn returns a random int in [0, n)
+func randIntn(n int) int {
+	randMux.Lock()
+	defer randMux.Unlock()
+	return random.Intn(n)
+}
+
+// defaultBrowserProfiles built-in profiles
+func defaultBrowserProfiles() []*BrowserProfile {
+	chromeAccept := "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,image/apng,*/*;q=0.8,application/signed-exchange;v=b3;q=0.7"
+	chrome := func(brand, platform string, mobile bool) Header {
+		h := Header{
+			"Accept":                    chromeAccept,
+			"Accept-Language":           "en-US,en;q=0.9",
+			"Sec-Ch-Ua":                 `"Chromium";v="124", "` + brand + `";v="124", "Not-A.Brand";v="99"`,
+			"Sec-Ch-Ua-Mobile":          "?0",
+			"Sec-Ch-Ua-Platform":        `"` + platform + `"`,
+			"Sec-Fetch-Dest":            "document",
+			"Sec-Fetch-Mode":            "navigate",
+			"Sec-Fetch-Site":            "none",
+			"Sec-Fetch-User":            "?1",
+			"Upgrade-Insecure-Requests": "1",
+		}
+		if mobile {
+			h["Sec-Ch-Ua-Mobile"] = "?1"
+		}
+		return h
+	}
+	firefox := Header{
+		"Accept":                    "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,*/*;q=0.8",
+		"Accept-Language":           "en-US,en;q=0.5",
+		"Sec-Fetch-Dest":            "document",
+		"Sec-Fetch-Mode":            "navigate",
+		"Sec-Fetch-Site":            "none",
+		"Sec-Fetch-User":            "?1",
+		"Upgrade-Insecure-Requests": "1",
+	}
+	safari := Header{
+		"Accept":          "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8",
+		"Accept-Language": "en-US,en;q=0.9",
+		"Sec-Fetch-Dest":  "document",
+		"Sec-Fetch-Mode":  "navigate",
+		"Sec-Fetch-Site":  "none",
+	}
+
+	return []*BrowserProfile{
+		{
+			Name:       "chrome-windows",
+			Browser:    "chrome",
+			OS:         "windows",
+			Device:     DeviceDesktop,
+			UserAgent:  "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36",
+			Header:     chrome("Google Chrome", "Windows", false),
+			TLSProfile: TLSProfileChrome,
+			Weight:     6,
+		},
+		{
+			Name:       "chrome-macos",
+			Browser:    "chrome",
+			OS:         "macos",
+			Device:     DeviceDesktop,
+			UserAgent:  "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36",
+			Header:     chrome("Google Chrome", "macOS", false),
+			TLSProfile: TLSProfileChrome,
+			Weight:     2,
+		},
+		{
+			Name:       "edge-windows",
+			Browser:    "edge",
+			OS:         "windows",
+			Device:     DeviceDesktop,
+			UserAgent:  "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36 Edg/124.0.0.0",
+			Header:     chrome("Microsoft Edge", "Windows", false),
+			TLSProfile: TLSProfileChrome,
+			Weight:     2,
+		},
+		{
+			Name:       "firefox-windows",
+			Browser:    "firefox",
+			OS:         "windows",
+			Device:     DeviceDesktop,
+			UserAgent:  "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:125.0) Gecko/20100101 Firefox/125.0",
+			Header:     firefox,
+			TLSProfile: TLSProfileFirefox,
+			Weight:     1,
+		},
+		{
+			Name:       "safari-macos",
+			Browser:    "safari",
+			OS:         "macos",
+			Device:     DeviceDesktop,
+			UserAgent:  "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Safari/605.1.15",
+			Header:     safari,
+			TLSProfile: TLSProfileSafari,
+			Weight:     1,
+		},
+		{
+			Name:       "chrome-android",
+			Browser:    "chrome",
+			OS:         "android",
+			Device:     DeviceMobile,
+			UserAgent:  "Mozilla/5.0 (Linux; Android 10; K) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Mobile Safari/537.36",
+			Header:     chrome("Google Chrome", "Android", true),
+			TLSProfile: TLSProfileChrome,
+			Weight:     3,
+		},
+		{
+			Name:       "safari-iphone",
+			Browser:    "safari",
+			OS:         "ios",
+			Device:     DeviceMobile,
+			UserAgent:  "Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1",
+			Header:     safari,
+			TLSProfile: TLSProfileSafari,
+			Weight:     2,
+		},
+		{
+			Name:       "safari-ipad",
+			Browser:    "safari",
+			OS:         "ios",
+			Device:     DeviceTablet,
+			UserAgent:  "Mozilla/5.0 (iPad; CPU OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1",
+			Header:     safari,
+			TLSProfile: TLSProfileSafari,
+			Weight:     1,
+		},
+	}
+}
@@ -0,0 +1,108 @@
+package esme
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func Test_BrowserProfiles(t *testing.T) {
+	profiles := DefaultBrowserProfiles()
+	for _, item := range profiles.Profiles() {
+		if item.UserAgent == "" || item.Header["Accept"] == "" || GetTLSProfile(item.TLSProfile) == nil {
+			t.Fatalf("profile %s", item.Name)
+		}
+	}
+	mobile := profiles.Filter("chrome", "", DeviceMobile).Profiles()
+	if len(mobile) != 1 || mobile[0].Name != "chrome-android" || mobile[0].Header["Sec-Ch-Ua-Mobile"] != "?1" {
+		t.Fatalf("mobile %v", mobile)
+	}
+	if profiles.Get("safari-macos") == nil || profiles.Get("netscape") != nil {
+		t.Fatal("get")
+	}
+
+	// explicit headers are kept
+	header := http.Header{}
+	header.Set("Accept-Language", "zh-CN")
+	header.Set("User-Agent", defaultUserAgent)
+	profiles.Get("chrome-windows").Apply(header)
+	if header.Get("Accept-Language") != "zh-CN" || header.Get("User-Agent") != profiles.Get("chrome-windows").UserAgent ||
+		header.Get("Sec-Ch-Ua-Platform") != `"Windows"` {
+		t.Fatalf("header %v", header)
+	}
+
+	// weighted choice
+	seen := map[string]int{}
+	weighted := NewBrowserProfiles(&BrowserProfile{Name: "a", UserAgent: "a", Weight: 9}, &BrowserProfile{Name: "b", UserAgent: "b"})
+	for i := 0; i < 1000; i++ {
+		seen[weighted.Random().Name]++
+	}
+	if seen["a"] < 800 || seen["b"] == 0 {
+		t.Fatalf("seen %v", seen)
+	}
+	if NewBrowserProfiles().Random() != nil {
+		t.Fatal("empty profiles")
+	}
+}
+
+func Test_LoadBrowserProfiles(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "profiles.json")
+	_ = os.WriteFile(file, []byte(`[{"name":"bot","browser":"esme","user_agent":"esme/2.0","header":{"Accept":"*/*"}}]`), 0600)
+	profiles, err := LoadBrowserProfiles(file)
+	if err != nil || profiles.Random().UserAgent != "esme/2.0" {
+		t.Fatalf("profiles %v err %v", profiles, err)
+	}
+
+	// reload
+	_ = os.WriteFile(file, []byte(`[{"name":"bot","user_agent":"esme/3.0"}]`), 0600)
+	if err = profiles.Load(file); err != nil || profiles.Get("bot").UserAgent != "esme/3.0" {
+		t.Fatalf("err %v", err)
+	}
+	_ = os.WriteFile(file, []byte(`[{"name":"bot"}]`), 0600)
+	if err = profiles.Load(file); err == nil || profiles.Get("bot").UserAgent != "esme/3.0" {
+		t.Fatalf("err %v", err)
+	}
+}
+
+func Test_JobBrowserProfile(t *testing.T) {
+	var (
+		mux    sync.Mutex
+		agents = map[string]map[string]bool{}
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.Lock()
+		session := r.URL.Query().Get("session")
+		if agents[session] == nil {
+			agents[session] = map[string]bool{}
+		}
+		agents[session][r.UserAgent()] = true
+		mux.Unlock()
+	}))
+	defer ts.Close()
+
+	profiles := NewBrowserProfiles(
+		&BrowserProfile{Name: "a", UserAgent: "a"},
+		&BrowserProfile{Name: "b", UserAgent: "b"},
+		&BrowserProfile{Name: "c", UserAgent: "c"},
+	)
+	queue := NewMemQueue()
+	for i := 0; i < 10; i++ {
+		for _, session := range []string{"1", "2"} {
+			queue.Add(&Task{Url: ts.URL + "/?session=" + session, Method: "GET", Session: session})
+		}
+	}
+	header := http.Header{}
+	header.Set("User-Agent", "explicit")
+	queue.Add(&Task{Url: ts.URL + "/?session=explicit", Method: "GET", Header: &header, Session: "1"})
+
+	NewJob("profiles", 3, queue, JobOptions{
+		BrowserProfiles: profiles,
+		ProfileRotation: RotatePerSession,
+	}).Do()
+	if len(agents["1"]) != 1 || len(agents["2"]) != 1 || !agents["explicit"]["explicit"] {
+		t.Fatalf("agents %v", agents)
+	}
+}
@@ -0,0 +1,188 @@
+/*
+cache.go
+storage backends of the http response cache
+*/
+
+package esme
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/zituocn/esme/goredis"
+	"github.com/zituocn/esme/logx"
+)
+
+// Cache storage of cached http responses
+type Cache interface {
+
+	// Get returns the value of key
+	Get(key string) ([]byte, bool)
+
+	// Set store the value of key
+	Set(key string, value []byte)
+
+	// Delete remove key
+	Delete(key string)
+}
+
+// MemCache in-memory cache
+type MemCache struct {
+	mux   *sync.RWMutex
+	items map[string][]byte
+}
+
+// NewMemCache returns an in-memory cache
+func NewMemCache() Cache {
+	return &MemCache{
+		mux:   &sync.RWMutex{},
+		items: make(map[string][]byte),
+	}
+}
+
+// Get returns the value of key
+func (c *MemCache) Get(key string) ([]byte, bool) {
+	c.mux.RLock()
+	defer c.mux.RUnlock()
+	v, ok := c.items[key]
+	return v, ok
+}
+
+// Set store the value of key
+func (c *MemCache) Set(key string, value []byte) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.items[key] = value
+}
+
+// Delete remove key
+func (c *MemCache) Delete(key string) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	delete(c.items, key)
+}
+
+// DiskCache cache stored as files in a directory
+//	one file per key, named by the sha1 of the key
+type DiskCache struct {
+	dir string
+}
+
+// NewDiskCache returns a cache stored in dir
+//	dir is created when it does not exist
+func NewDiskCache(dir string) Cache {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		logx.Errorf("create cache dir failed : %v", err)
+	}
+	return &DiskCache{
+		dir: dir,
+	}
+}
+
+// Get returns the value of key
+func (c *DiskCache) Get(key string) ([]byte, bool) {
+	b, err := ioutil.ReadFile(c.filename(key))
+	if err != nil {
+		return nil, false
+	}
+	return b, true
+}
+
+// Set store the value of key
+//	written to a temporary file first so readers never see half a file
+func (c *DiskCache) Set(key string, value []byte) {
+	f, err := ioutil.TempFile(c.dir, "tmp-")
+	if err != nil {
+		logx.Errorf("write cache failed : %v", err)
+		return
+	}
+	_, err = f.Write(value)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), c.filename(key))
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		logx.Errorf("write cache failed : %v", err)
+	}
+}
+
+// Delete remove key
+func (c *DiskCache) Delete(key string) {
+	_ = os.Remove(c.filename(key))
+}
+
+func (c *DiskCache) filename(key string) string {
+	sum := sha1.Sum([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:]))
+}
+
+// RedisCache cache stored in redis
+type RedisCache struct {
+
+	// prefix prefix of redis keys
+	prefix string
+
+	// ttl expiration of redis keys, 0 means no expiration
+	ttl time.Duration
+
+	// rdb redis client
+	rdb *redis.Client
+}
+
+// NewRedisCache use redis configuration
+//	keys are stored as prefix + sha1(key) and expire after ttl
+func NewRedisCache(prefix string, ttl time.Duration, rc *goredis.RedisConfig) Cache {
+	err := goredis.InitDefaultDB(rc)
+	if err != nil {
+		logx.Error(err)
+		return nil
+	}
+
+	return &RedisCache{
+		prefix: prefix,
+		ttl:    ttl,
+		rdb:    goredis.GetRDB(),
+	}
+}
+
+// Get returns the value of key
+func (c *RedisCache) Get(key string) ([]byte, bool) {
+	b, err := c.rdb.Get(ctx, c.redisKey(key)).Bytes()
+	if err != nil {
+		if err != redis.Nil {
+			logx.Errorf("get cache failed : %v", err)
+		}
+		return nil, false
+	}
+	return b, true
+}
+
+// Set store the value of key
+func (c *RedisCache) Set(key string, value []byte) {
+	err := c.rdb.Set(ctx, c.redisKey(key), value, c.ttl).Err()
+	if err != nil {
+		logx.Errorf("set cache failed : %v", err)
+	}
+}
+
+// Delete remove key
+func (c *RedisCache) Delete(key string) {
+	err := c.rdb.Del(ctx, c.redisKey(key)).Err()
+	if err != nil {
+		logx.Errorf("delete cache failed : %v", err)
+	}
+}
+
+func (c *RedisCache) redisKey(key string) string {
+	sum := sha1.Sum([]byte(key))
+	return c.prefix + hex.EncodeToString(sum[:])
+}
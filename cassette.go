@@ -0,0 +1,367 @@
+/*
+cassette.go
+record http interactions to a file and replay them offline
+*/
+
+package esme
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"unicode/utf8"
+)
+
+// CassetteMode how a cassette is used
+type CassetteMode int
+
+const (
+
+	// CassetteReplay serve recorded responses only,
+	//	a request without a recorded interaction returns an error
+	CassetteReplay CassetteMode = iota
+
+	// CassetteRecord send every request and record it
+	CassetteRecord
+
+	// CassetteReplayOrRecord replay recorded interactions, record the others
+	CassetteReplayOrRecord
+)
+
+const (
+
+	// redactedValue recorded value of a sensitive header
+	redactedValue = "REDACTED"
+)
+
+var (
+
+	// sensitiveHeaders request headers redacted by default
+	sensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "X-Api-Key", "X-Auth-Token"}
+
+	// sensitiveResponseHeaders response headers redacted by default
+	sensitiveResponseHeaders = []string{"Set-Cookie", "Set-Cookie2"}
+)
+
+// CassetteMatcher parts of a request compared when replaying
+type CassetteMatcher struct {
+	Method bool
+
+	URL bool
+
+	Body bool
+
+	// Headers names of the headers compared
+	Headers []string
+
+	// IgnoreQuery query parameters left out when comparing urls,
+	//	like the timestamp and the signature of a ParamSigner
+	IgnoreQuery []string
+}
+
+// Cassette recorded http interactions
+//	saved as json to its file by Close, a Job closes its cassette when it is done
+type Cassette struct {
+	mux *sync.Mutex
+
+	filename string
+
+	mode CassetteMode
+
+	// Matcher how requests are matched to interactions
+	//	default compares method and url
+	Matcher CassetteMatcher `json:"-"`
+
+	// KeepSensitiveHeaders record the values of sensitive headers,
+	//	by default Authorization, Cookie, Set-Cookie and other credentials are recorded as "REDACTED"
+	KeepSensitiveHeaders bool `json:"-"`
+
+	// SensitiveHeaders names of more request and response headers to redact, like "X-Token"
+	SensitiveHeaders []string `json:"-"`
+
+	// Interactions recorded request and response pairs
+	Interactions []*Interaction `json:"interactions"`
+
+	// used interactions already replayed
+	used map[int]bool
+
+	// dirty interactions were recorded since the last save
+	dirty bool
+}
+
+// Interaction a recorded request and response
+type Interaction struct {
+	Request  *RecordedRequest  `json:"request"`
+	Response *RecordedResponse `json:"response"`
+}
+
+// RecordedRequest a recorded http request
+type RecordedRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header"`
+	Body   string      `json:"body"`
+
+	// BodyBase64 the body is not utf-8 and is base64 encoded
+	BodyBase64 bool `json:"body_base64,omitempty"`
+}
+
+// RecordedResponse a recorded http response
+type RecordedResponse struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header"`
+	Body       string      `json:"body"`
+
+	// BodyBase64 the body is not utf-8 and is base64 encoded
+	BodyBase64 bool `json:"body_base64,omitempty"`
+}
+
+// NewCassette returns a *Cassette stored in filename
+//	interactions in an existing file are loaded
+func NewCassette(filename string, mode CassetteMode) (*Cassette, error) {
+	c := &Cassette{
+		mux:      &sync.Mutex{},
+		filename: filename,
+		mode:     mode,
+		Matcher: CassetteMatcher{
+			Method: true,
+			URL:    true,
+		},
+		Interactions: make([]*Interaction, 0),
+		used:         make(map[int]bool),
+	}
+	b, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		if mode == CassetteReplay {
+			return nil, fmt.Errorf("cassette not found: %s", filename)
+		}
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(b, c); err != nil {
+		return nil, fmt.Errorf("decode cassette %s : %s", filename, err.Error())
+	}
+	return c, nil
+}
+
+// Save write the cassette to its file
+func (c *Cassette) Save() error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.save()
+}
+
+// Close write the cassette to its file when interactions were recorded,
+//	the cassette can still be used after
+func (c *Cassette) Close() error {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if !c.dirty {
+		return nil
+	}
+	return c.save()
+}
+
+// SetCassette record or replay the requests of the context with a cassette
+func (c *Context) SetCassette(cassette *Cassette) *Context {
+	if cassette == nil {
+		return c
+	}
+	c.cassette = cassette
+	return c
+}
+
+/*
+private
+*/
+
+func (c *Cassette) save() error {
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(c.filename, b, 0644); err != nil {
+		return err
+	}
+	c.dirty = false
+	return nil
+}
+
+// find returns the first unused interaction matching req
+//	when all matching interactions are used the last one is replayed again
+func (c *Cassette) find(req *RecordedRequest) *Interaction {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	last := -1
+	for i, item := range c.Interactions {
+		if !c.match(item.Request, req) {
+			continue
+		}
+		if !c.used[i] {
+			c.used[i] = true
+			return item
+		}
+		last = i
+	}
+	if last >= 0 {
+		return c.Interactions[last]
+	}
+	return nil
+}
+
+// redact returns header with the values of sensitive headers replaced
+//	defaults are sensitiveHeaders or sensitiveResponseHeaders
+func (c *Cassette) redact(header http.Header, defaults []string) http.Header {
+	if c.KeepSensitiveHeaders {
+		return header
+	}
+	names := append(append([]string{}, defaults...), c.SensitiveHeaders...)
+	for _, name := range names {
+		name = http.CanonicalHeaderKey(name)
+		if _, ok := header[name]; ok {
+			header[name] = []string{redactedValue}
+		}
+	}
+	return header
+}
+
+func (c *Cassette) match(recorded, req *RecordedRequest) bool {
+	m := c.Matcher
+	if m.Method && recorded.Method != req.Method {
+		return false
+	}
+	if m.URL && withoutQuery(recorded.URL, m.IgnoreQuery) != withoutQuery(req.URL, m.IgnoreQuery) {
+		return false
+	}
+	if m.Body && (recorded.Body != req.Body || recorded.BodyBase64 != req.BodyBase64) {
+		return false
+	}
+	for _, name := range m.Headers {
+		if recorded.Header.Get(name) != req.Header.Get(name) {
+			return false
+		}
+	}
+	return true
+}
+
+// record add an interaction, saved by Close
+func (c *Cassette) record(item *Interaction) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.Interactions = append(c.Interactions, item)
+	c.used[len(c.Interactions)-1] = true
+	c.dirty = true
+}
+
+// cassetteTransport record or replay requests with a cassette
+type cassetteTransport struct {
+	base     http.RoundTripper
+	cassette *Cassette
+}
+
+func (t *cassetteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	recordedReq := &RecordedRequest{
+		Method: req.Method,
+		URL:    req.URL.String(),
+		Header: t.cassette.redact(req.Header.Clone(), sensitiveHeaders),
+	}
+	recordedReq.Body, recordedReq.BodyBase64 = encodeBody(body)
+
+	if t.cassette.mode != CassetteRecord {
+		if item := t.cassette.find(recordedReq); item != nil {
+			return item.Response.response(req)
+		}
+		if t.cassette.mode == CassetteReplay {
+			return nil, fmt.Errorf("cassette: no recorded interaction for %s %s", req.Method, req.URL)
+		}
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	// the body is bounded by the limits of the context, a larger one is not recorded
+	respBody, err := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+
+	recordedResp := &RecordedResponse{
+		StatusCode: resp.StatusCode,
+		Header:     t.cassette.redact(resp.Header.Clone(), sensitiveResponseHeaders),
+	}
+	recordedResp.Body, recordedResp.BodyBase64 = encodeBody(respBody)
+	t.cassette.record(&Interaction{Request: recordedReq, Response: recordedResp})
+	return resp, nil
+}
+
+// response returns a new *http.Response of the recorded response
+func (r *RecordedResponse) response(req *http.Request) (*http.Response, error) {
+	body, err := decodeBody(r.Body, r.BodyBase64)
+	if err != nil {
+		return nil, err
+	}
+	header := r.Header.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", r.StatusCode, http.StatusText(r.StatusCode)),
+		StatusCode:    r.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// readRequestBody returns the body of req and restores it
+func readRequestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	if req.GetBody != nil {
+		r, err := req.GetBody()
+		if err == nil {
+			defer r.Close()
+			return ioutil.ReadAll(r)
+		}
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	_ = req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+func encodeBody(b []byte) (string, bool) {
+	if utf8.Valid(b) {
+		return string(b), false
+	}
+	return base64.StdEncoding.EncodeToString(b), true
+}
+
+func decodeBody(s string, isBase64 bool) ([]byte, error) {
+	if isBase64 {
+		return base64.StdEncoding.DecodeString(s)
+	}
+	return []byte(s), nil
+}
@@ -0,0 +1,114 @@
+package esme
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func Test_CassetteRecordAndReplay(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		_, _ = fmt.Fprintf(w, "%s %s %s", r.Method, r.URL.Path, body)
+	}))
+	filename := filepath.Join(t.TempDir(), "cassette.json")
+
+	cassette, err := NewCassette(filename, CassetteRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue := NewMemQueue()
+	queue.Add(&Task{Url: ts.URL + "/a", Method: "GET"})
+	queue.Add(&Task{Url: ts.URL + "/b", Method: "POST", Payload: []byte("page=1")})
+	queue.Add(&Task{Url: ts.URL + "/b", Method: "POST", Payload: []byte("page=2")})
+	NewJob("record", 1, queue, JobOptions{Cassette: cassette}).Do()
+	ts.Close()
+
+	cassette, err = NewCassette(filename, CassetteReplay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cassette.Interactions) != 3 {
+		t.Fatalf("interactions: got %d", len(cassette.Interactions))
+	}
+	cassette.Matcher.Body = true
+
+	ctx := HttpPost(ts.URL+"/b", []byte("page=2")).SetCassette(cassette)
+	ctx.Do()
+	if ctx.ToString() != "POST /b page=2" {
+		t.Fatalf("replay: got %q", ctx.ToString())
+	}
+
+	ctx = HttpGet(ts.URL + "/missing").SetCassette(cassette)
+	ctx.Do()
+	if ctx.Err == nil {
+		t.Fatal("expected error for a request without interaction")
+	}
+}
+
+func Test_CassetteMaxBodySize(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 64; i++ {
+			_, _ = w.Write(bytes.Repeat([]byte("a"), 16*1024))
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer ts.Close()
+
+	cassette, err := NewCassette(filepath.Join(t.TempDir(), "cassette.json"), CassetteRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := HttpGet(ts.URL).SetCassette(cassette).SetMaxBodySize(1024)
+	var reqErr *RequestError
+	if err = ctx.Do(); !errors.As(err, &reqErr) || reqErr.Kind != ErrorLimit {
+		t.Fatalf("err %v", err)
+	}
+	if len(cassette.Interactions) != 0 {
+		t.Fatalf("interactions: got %d", len(cassette.Interactions))
+	}
+}
+
+func Test_CassetteRedact(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "sid", Value: "secret"})
+		_, _ = fmt.Fprint(w, r.Header.Get("Authorization") == "Bearer secret")
+	}))
+	defer ts.Close()
+	filename := filepath.Join(t.TempDir(), "cassette.json")
+
+	cassette, err := NewCassette(filename, CassetteRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cassette.SensitiveHeaders = []string{"x-token"}
+	header := Header{"Cookie": "sid=secret", "X-Token": "secret", "Accept": "text/html"}
+	ctx := HttpGet(ts.URL, header).SetAuth(&BearerAuth{Token: "secret"}).SetCassette(cassette)
+	if err = ctx.Do(); err != nil || ctx.ToString() != "true" {
+		t.Fatalf("err %v body %s", err, ctx.ToString())
+	}
+	// the file is written once, by Close
+	if _, err = ioutil.ReadFile(filename); err == nil {
+		t.Fatal("cassette saved before Close")
+	}
+	if err = cassette.Close(); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadFile(filename)
+	if bytes.Contains(b, []byte("secret")) || !bytes.Contains(b, []byte("text/html")) || !bytes.Contains(b, []byte("Set-Cookie")) {
+		t.Fatalf("cassette: %s", b)
+	}
+
+	cassette, _ = NewCassette(filepath.Join(t.TempDir(), "cassette.json"), CassetteRecord)
+	cassette.KeepSensitiveHeaders = true
+	HttpGet(ts.URL).SetAuth(&BearerAuth{Token: "secret"}).SetCassette(cassette).Do()
+	if item := cassette.Interactions[0]; item.Request.Header.Get("Authorization") != "Bearer secret" ||
+		item.Response.Header.Get("Set-Cookie") != "sid=secret" {
+		t.Fatalf("header: got %v %v", item.Request.Header, item.Response.Header)
+	}
+}
@@ -0,0 +1,71 @@
+/*
+charset.go
+response body charset detection and decoding
+*/
+
+package esme
+
+import (
+	"bytes"
+	"io/ioutil"
+	"unicode/utf8"
+
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+)
+
+// Charset returns the encoding name of the response body
+//	detected from the Content-Type header, BOM and <meta> tags,
+//	an undeclared body is utf-8 when it is valid utf-8
+//	like: utf-8 gbk gb18030 big5
+func (c *Context) Charset() string {
+	if len(c.RespBody) == 0 {
+		return ""
+	}
+	_, name := c.encoding()
+	return name
+}
+
+// ToUTF8 returns the response body converted to utf-8
+//	the result is cached on the context
+func (c *Context) ToUTF8() []byte {
+	if c.utf8Body != nil {
+		return c.utf8Body
+	}
+	if len(c.RespBody) == 0 {
+		return []byte("")
+	}
+	c.utf8Body = c.RespBody
+	enc, name := c.encoding()
+	if name == "utf-8" {
+		return c.utf8Body
+	}
+	body, err := ioutil.ReadAll(enc.NewDecoder().Reader(bytes.NewReader(c.RespBody)))
+	if err == nil {
+		c.utf8Body = body
+	}
+	return c.utf8Body
+}
+
+/*
+private
+*/
+
+// encoding returns the encoding of the response body
+//	DetermineEncoding only looks at the first 1024 bytes and falls back to windows-1252,
+//	which garbles a utf-8 body whose first non-ascii char comes later
+func (c *Context) encoding() (encoding.Encoding, string) {
+	enc, name, certain := charset.DetermineEncoding(c.RespBody, c.contentType())
+	if !certain && name == "windows-1252" && utf8.Valid(c.RespBody) {
+		return encoding.Nop, "utf-8"
+	}
+	return enc, name
+}
+
+// contentType returns the Content-Type of the response
+func (c *Context) contentType() string {
+	if c.Response == nil {
+		return ""
+	}
+	return c.Response.Header.Get("Content-Type")
+}
@@ -0,0 +1,27 @@
+package esme
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_ToUTF8(t *testing.T) {
+	// the first non-ascii char is after the 1024 bytes sniffed by DetermineEncoding
+	body := "<html><body><p>" + strings.Repeat("a", 1100) + "</p><p>北京</p></body></html>"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte(body))
+	}))
+	defer ts.Close()
+
+	ctx := HttpGet(ts.URL)
+	ctx.Do()
+	if cs := ctx.Charset(); cs != "utf-8" {
+		t.Fatalf("charset: got %s", cs)
+	}
+	if s := string(ctx.ToUTF8()); s != body {
+		t.Fatalf("body is garbled: %s", s[len(s)-40:])
+	}
+}
@@ -9,17 +9,20 @@ package esme
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
-	"strings"
 	"time"
 
 	"github.com/tidwall/gjson"
 	"github.com/zituocn/esme/logx"
+	"golang.org/x/net/html"
 )
 
 // CallbackFunc call back func
@@ -52,6 +55,12 @@ type Context struct {
 	// callback to request try
 	retryFunc CallbackFunc
 
+	// maxRetries max times the request is executed again after a retry status code
+	maxRetries int
+
+	// retries times the request was executed again
+	retries int
+
 	// callback for request completion
 	completeFunc CallbackFunc
 
@@ -70,117 +79,176 @@ type Context struct {
 	// isDebug debug mode switch
 	isDebug bool
 
-	// execution time
+	// execution time, from the start of the request to the end of the body
 	execTime time.Duration
-}
 
-// Do execute current request
-func (c *Context) Do() {
-	var (
-		bodyBytes []byte
-		err       error
-	)
-
-	// set request body
-	if c.Request.Body != nil {
-		bodyBytes, _ = ioutil.ReadAll(c.Request.Body)
-		c.Request.Body = ioutil.NopCloser(bytes.NewBuffer(bodyBytes))
-	}
+	// timings timings of the last request
+	timings Timings
 
-	// sheep
-	if c.sleepTime > 0 {
-		time.Sleep(c.sleepTime)
-	}
+	// outcome result of the last request
+	outcome Outcome
 
-	// callback to execute start request
-	if c.startFunc != nil {
-		c.startFunc(c)
-	}
+	// errorFunc callback for request errors
+	errorFunc ErrorFunc
 
-	// start time
-	startTime := time.Now()
+	// buildErr error of creating the request, returned by Do
+	buildErr error
 
-	// start executing the request
-	c.Response, c.Err = c.client.Do(c.Request)
+	// utf8Body response body converted to utf-8
+	utf8Body []byte
 
-	if c.Err != nil {
-		//context deadline exceeded retry
-		if c.retryFunc != nil {
-			logx.Warnf("[%s] callback -> %s", "deadline", GetFuncName(c.retryFunc))
-			c.retryFunc(c)
-			return
-		} else {
-			logx.Errorf("request error: %s", c.Err.Error())
-			return
+	// htmlDoc parsed html document of the response
+	htmlDoc *HTMLDocument
+
+	// xmlDoc parsed xml document of the response
+	xmlDoc *html.Node
+
+	// cache response cache
+	cache Cache
+
+	// cacheMode how cached responses are used
+	cacheMode CacheMode
+
+	// cacheIgnoreQuery query parameters left out of the cache key
+	cacheIgnoreQuery []string
+
+	// cacheStatus how the response was served by the cache
+	cacheStatus CacheStatus
+
+	// cassette record or replay requests
+	cassette *Cassette
+
+	// har record requests as HAR
+	har *HARRecorder
+
+	// proxy http proxy of the request
+	proxy string
+
+	// redirect how redirects are followed
+	redirect *RedirectPolicy
+
+	// redirects redirects followed by the last request
+	redirects []*Redirect
+
+	// middlewares middlewares of the context
+	middlewares []Middleware
+
+	// maxBodySize max size of the response body, 0 means unlimited
+	maxBodySize int64
+
+	// allowedTypes content types of the responses read
+	allowedTypes []string
+
+	// bandwidths limits of the transfer rate of the bodies
+	bandwidths []*Bandwidth
+
+	// resolver dns resolver of the transport
+	resolver *Resolver
+
+	// tls tls options of the transport
+	tls *TLSOptions
+
+	// profile browser profile of the request
+	profile *BrowserProfile
+
+	// auth authentication of the request
+	auth Auth
+
+	// signer signer of the request
+	signer Signer
+}
+
+// contextKey key of the *Context in the context of the http request
+type contextKey struct{}
+
+// Do execute current request
+//	returns the error of the request, nil when a response is received.
+//	every outcome ends with the complete callback
+func (c *Context) Do() error {
+	var reqErr *RequestError
+	if c.buildErr != nil {
+		if !errors.As(c.buildErr, &reqErr) {
+			reqErr = newRequestError(ErrorRequest, c.buildErr)
+		}
+	} else {
+		// sheep
+		if c.sleepTime > 0 {
+			time.Sleep(c.sleepTime)
 		}
-	}
 
-	defer func(c *Context) {
-		if c.Response != nil {
-			err = c.Response.Body.Close()
-			if err != nil {
-				logx.Errorf("response body close error: %s", err.Error())
-			}
+		// callback to execute start request
+		if c.startFunc != nil {
+			c.startFunc(c)
 		}
-	}(c)
 
-	c.execTime = time.Now().Sub(startTime)
+		// start executing the request through the middlewares
+		reqErr = classifyError(c.handler()(c))
+	}
 
-	// gzip decode
-	if c.Response.Header.Get("Content-Encoding") == "gzip" {
-		c.Response.Body, err = gzip.NewReader(c.Response.Body)
-		if err != nil {
-			logx.Errorf("unzip failed: %s", err.Error())
-			return
+	if reqErr != nil {
+		c.Err = reqErr
+		c.outcome = OutcomeError
+		if reqErr.Kind == ErrorCancelled {
+			c.outcome = OutcomeCancelled
 		}
+		logx.Errorf("request error [%s]: %s", reqErr.Kind, reqErr.Error())
+		if c.errorFunc != nil {
+			c.errorFunc(c, reqErr)
+		}
+		if reqErr.Temporary() && c.retryFunc != nil {
+			// timeout and connection errors go to the retry callback
+			logx.Warnf("[%s] callback -> %s", reqErr.Kind, GetFuncName(c.retryFunc))
+			c.retryFunc(c)
+		} else if c.failedFunc != nil {
+			logx.Errorf("[%s] callback -> %s", c.outcome, GetFuncName(c.failedFunc))
+			c.failedFunc(c)
+		}
+		c.complete()
+		return c.Err
 	}
+	c.Err = nil
 
 	// http response
-	if c.Response != nil {
-		code := c.Response.StatusCode
-		status := GetStatusCodeString(code)
-		body, err := ioutil.ReadAll(c.Response.Body)
-		if err != nil {
-			logx.Errorf("read response body error: %s", err.Error())
-			logx.Debugf("task: %v", c.Task)
-			return
+	outcome := outcomeOf(c.Response.StatusCode)
+	if c.redirect != nil && c.redirect.NoFollow && GetStatusCodeString(c.Response.StatusCode) == "redirect" {
+		outcome = OutcomeSuccess
+	}
+	c.outcome = outcome
+	switch outcome {
+	case OutcomeSuccess:
+		// callback success function
+		if c.succeedFunc != nil {
+			logx.Infof("[%s] callback -> %s", outcome, GetFuncName(c.succeedFunc))
+			c.succeedFunc(c)
 		}
-		c.RespBody = body
-		switch status {
-		case "success":
-			// callback success function
-			if c.succeedFunc != nil {
-				logx.Infof("[%s] callback -> %s", status, GetFuncName(c.succeedFunc))
-				c.succeedFunc(c)
-			}
-		case "retry":
-			// callback retry function
-			if c.retryFunc != nil {
-				logx.Warnf("[%s] callback -> %s", status, GetFuncName(c.retryFunc))
-				c.retryFunc(c)
-				c.Do()
-			}
-		case "fail":
-			// callback failed function
+	case OutcomeRetry:
+		// callback retry function, then execute the request again, at most maxRetries times
+		if c.retryFunc != nil && c.retries < c.maxRetries && c.resendable() {
+			c.retries++
+			logx.Warnf("[%s] callback -> %s", outcome, GetFuncName(c.retryFunc))
+			c.retryFunc(c)
+			c.clearResponse()
+			return c.Do()
+		}
+		if c.retryFunc != nil {
+			// retries exhausted, the request failed
+			c.outcome = OutcomeFail
+			logx.Errorf("[%s] %d retries exhausted", outcome, c.retries)
 			if c.failedFunc != nil {
-				logx.Errorf("[%s] callback -> %s", status, GetFuncName(c.failedFunc))
+				logx.Errorf("[%s] callback -> %s", c.outcome, GetFuncName(c.failedFunc))
 				c.failedFunc(c)
 			}
-		default:
-			logx.Warnf("Unhandled status code: %d", code)
 		}
-
-	}
-
-	// callback completion function
-	if c.completeFunc != nil {
-		c.completeFunc(c)
+	case OutcomeFail:
+		// callback failed function
+		if c.failedFunc != nil {
+			logx.Errorf("[%s] callback -> %s", outcome, GetFuncName(c.failedFunc))
+			c.failedFunc(c)
+		}
 	}
 
-	// isDebug print
-	if c.isDebug {
-		c.debugPrint()
-	}
+	c.complete()
+	return nil
 }
 
 // SetIsDebug set debug
@@ -232,6 +300,16 @@ func (c *Context) SetRetryFunc(fn CallbackFunc) *Context {
 	return c
 }
 
+// SetMaxRetries set the max times the request is executed again after a retry status code
+//	3 by default
+func (c *Context) SetMaxRetries(n int) *Context {
+	if n <= 0 {
+		return c
+	}
+	c.maxRetries = n
+	return c
+}
+
 // SetProxy set http proxy
 func (c *Context) SetProxy(httpProxy string) *Context {
 	if httpProxy == "" {
@@ -240,7 +318,9 @@ func (c *Context) SetProxy(httpProxy string) *Context {
 	proxy, _ := url.Parse(httpProxy)
 	transport := getDefaultTransport()
 	transport.Proxy = http.ProxyURL(proxy)
+	c.configureTransport(transport)
 	c.client.Transport = transport
+	c.proxy = httpProxy
 	return c
 }
 
@@ -298,18 +378,13 @@ func (c *Context) ToJSON(v interface{}) error {
 
 // ToHTML returns string
 //	response body to html code
+//	converted to utf-8 with all html entities unescaped
 func (c *Context) ToHTML() string {
-	s := c.ToString()
-	return strings.NewReplacer(
-		"&amp;", "&",
-		"&lt;", "<",
-		"&gt;", ">",
-		"&#34;", `"`,
-		"&#39;", "'",
-	).Replace(s)
+	return html.UnescapeString(string(c.ToUTF8()))
 }
 
 // GetExecTime get request execution time
+//	the total time of Timings, including reading the body
 func (c *Context) GetExecTime() time.Duration {
 	return c.execTime
 }
@@ -318,18 +393,176 @@ func (c *Context) GetExecTime() time.Duration {
 private
 */
 
+// transport returns the transport of the client
+//	wrapped by the limits, the cassette, the cache and the HAR recorder
+func (c *Context) transport() http.RoundTripper {
+	var rt http.RoundTripper = http.DefaultTransport
+	if c.client.Transport != nil {
+		rt = c.client.Transport
+	}
+	if c.maxBodySize > 0 || len(c.allowedTypes) > 0 || len(c.bandwidths) > 0 {
+		rt = &limitTransport{base: rt, ctx: c}
+	}
+	if c.cassette != nil {
+		rt = &cassetteTransport{base: rt, cassette: c.cassette}
+	}
+	if c.cache != nil {
+		rt = &cacheTransport{base: rt, cache: c.cache, mode: c.cacheMode, ignoreQuery: c.cacheIgnoreQuery}
+	}
+	if c.har != nil {
+		rt = &harTransport{base: rt, recorder: c.har}
+	}
+	return rt
+}
+
+// resendable returns whether the body of the request can be sent again
+func (c *Context) resendable() bool {
+	if c.Request == nil || c.Request.GetBody == nil {
+		return true
+	}
+	body, err := c.Request.GetBody()
+	if err != nil {
+		return false
+	}
+	_ = body.Close()
+	return true
+}
+
+// send execute the http request and read the response body
+//	the innermost Handler of the middleware chain
+func send(c *Context) error {
+	var err error
+
+	// set request body, every attempt sends a new copy of the body
+	if c.Request.GetBody == nil && c.Request.Body != nil && c.Request.Body != http.NoBody {
+		bodyBytes, _ := ioutil.ReadAll(c.Request.Body)
+		c.Request.GetBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(bodyBytes)), nil
+		}
+	}
+	if c.Request.GetBody != nil {
+		c.Request.Body, err = c.Request.GetBody()
+		if err != nil {
+			return newRequestError(ErrorRequest, fmt.Errorf("request body error: %s", err.Error()))
+		}
+		c.Request.Body = c.throttle(c.Request.Body)
+	}
+
+	// timings of the request, until the body is read
+	tr := newTracer()
+	var header time.Time
+	defer func() {
+		c.timings = tr.finish(header, time.Now())
+		if c.proxy != "" {
+			c.timings.Proxy = maskProxy(c.proxy)
+		}
+		c.execTime = c.timings.Total
+	}()
+
+	c.cacheStatus = CacheNone
+	c.redirects = nil
+	client := *c.client
+	client.Transport = c.transport()
+	client.CheckRedirect = c.checkRedirect(c.client.CheckRedirect)
+	reqCtx := context.WithValue(c.Request.Context(), contextKey{}, c)
+	req := c.Request.WithContext(httptrace.WithClientTrace(reqCtx, tr.clientTrace()))
+	c.Response, err = client.Do(req)
+	if err != nil {
+		return err
+	}
+	header = time.Now()
+
+	defer func(body io.ReadCloser) {
+		if err := body.Close(); err != nil {
+			logx.Errorf("response body close error: %s", err.Error())
+		}
+	}(c.Response.Body)
+
+	// limits checked again for responses of the cache, the cassette and middlewares
+	if err = c.checkResponse(c.Response); err != nil {
+		return newRequestError(ErrorLimit, err)
+	}
+
+	// gzip decode
+	reader := io.Reader(c.Response.Body)
+	if c.Response.Header.Get("Content-Encoding") == "gzip" {
+		reader, err = gzip.NewReader(reader)
+		if err != nil {
+			return newRequestError(ErrorDecode, fmt.Errorf("unzip failed: %s", err.Error()))
+		}
+	}
+
+	// the limit applies to the decoded body
+	if c.maxBodySize > 0 {
+		reader = &limitedBody{r: reader, limit: c.maxBodySize, size: c.Response.ContentLength}
+	}
+
+	body, err := ioutil.ReadAll(reader)
+	if err != nil {
+		logx.Debugf("task: %v", c.Task)
+		var sizeErr *BodySizeError
+		if errors.As(err, &sizeErr) {
+			return newRequestError(ErrorLimit, err)
+		}
+		if kind := errorKind(err); kind == ErrorTimeout || kind == ErrorCancelled {
+			return newRequestError(kind, err)
+		}
+		return newRequestError(ErrorDecode, fmt.Errorf("read response body error: %s", err.Error()))
+	}
+	c.reset()
+	c.RespBody = body
+	c.Response.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return nil
+}
+
+// contextFromRequest returns the *Context executing req
+func contextFromRequest(req *http.Request) *Context {
+	c, _ := req.Context().Value(contextKey{}).(*Context)
+	return c
+}
+
+// complete callback completion function and debug print
+func (c *Context) complete() {
+	if c.completeFunc != nil {
+		c.completeFunc(c)
+	}
+	if c.isDebug {
+		c.debugPrint()
+	}
+}
+
 // debugPrint print request and response detail
 func (c *Context) debugPrint() {
+	if c.Response == nil {
+		fmt.Printf("%s %v \n", leftText("URL:"), c.Request.URL)
+		fmt.Printf("%s %v \n", leftText("User-Agent:"), c.UserAgent())
+		fmt.Printf("%s %v \n", leftText("Error:"), c.Err)
+		fmt.Printf("%s %v \n", leftText("Timings:"), c.timings)
+		return
+	}
 
 	fmt.Printf("%s %v \n", leftText("URL:"), c.Request.URL)
 	fmt.Printf("%s %v \n", leftText("Method:"), c.Request.Method)
 	fmt.Printf("%s %v \n", leftText("Request Header:"), c.Request.Header)
 	fmt.Printf("%s %v \n", leftText("Response code:"), c.Response.StatusCode)
 	fmt.Printf("%s %v \n", leftText("Response Header:"), c.Response.Header)
+	fmt.Printf("%s %v \n", leftText("Timings:"), c.timings)
 
 }
 
+// reset clear the cached results of the previous response
 func (c *Context) reset() {
+	c.utf8Body = nil
+	c.htmlDoc = nil
+	c.xmlDoc = nil
+}
+
+// clearResponse remove the response of the previous attempt,
+//	a failed attempt must not leave it to the callbacks
+func (c *Context) clearResponse() {
+	c.Response = nil
+	c.RespBody = nil
+	c.reset()
 }
 
 func leftText(s string) string {
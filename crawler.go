@@ -0,0 +1,189 @@
+/*
+crawler.go
+follow links of html responses within crawl rules
+*/
+
+package esme
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/zituocn/esme/logx"
+)
+
+// credentialHeaders headers of a task only copied to links of the same host
+var credentialHeaders = []string{"Authorization", "Cookie", "Proxy-Authorization"}
+
+// CrawlRules scope of the links followed by a Crawler
+type CrawlRules struct {
+
+	// AllowedDomains domains allowed to crawl, subdomains included
+	//	empty means all domains
+	AllowedDomains []string
+
+	// Include regular expressions, a link must match one of them
+	//	empty means all links
+	Include []string
+
+	// Exclude regular expressions, a link matching any of them is dropped
+	Exclude []string
+
+	// MaxDepth max depth of links from the seeds
+	//	seeds are depth 0, 0 means unlimited
+	MaxDepth int
+}
+
+// Crawler a Job that follows the links of html responses
+type Crawler struct {
+	*Job
+
+	rules CrawlRules
+
+	include []*regexp.Regexp
+
+	exclude []*regexp.Regexp
+
+	// seen links already added to the queue
+	seen sync.Map
+}
+
+// NewCrawler returns a *Crawler
+//	links of successful html responses are extracted after options.SucceedFunc,
+//	filtered by rules and added to queue as new tasks with Task.Depth + 1
+func NewCrawler(name string, num int, queue TodoQueue, options JobOptions, rules CrawlRules) (*Crawler, error) {
+	c := &Crawler{
+		rules: rules,
+	}
+	for _, item := range rules.Include {
+		re, err := regexp.Compile(item)
+		if err != nil {
+			return nil, err
+		}
+		c.include = append(c.include, re)
+	}
+	for _, item := range rules.Exclude {
+		re, err := regexp.Compile(item)
+		if err != nil {
+			return nil, err
+		}
+		c.exclude = append(c.exclude, re)
+	}
+
+	succeedFunc := options.SucceedFunc
+	options.SucceedFunc = func(ctx *Context) {
+		if succeedFunc != nil {
+			succeedFunc(ctx)
+		}
+		c.follow(ctx)
+	}
+	c.Job = NewJob(name, num, queue, options)
+	return c, nil
+}
+
+// AddSeed add seed urls at depth 0
+func (c *Crawler) AddSeed(urls ...string) {
+	for _, item := range urls {
+		link, ok := NormalizeURL(nil, item)
+		if !ok {
+			logx.Warnf("invalid seed url: %s", item)
+			continue
+		}
+		if _, loaded := c.seen.LoadOrStore(link, true); loaded {
+			continue
+		}
+		c.queue.Add(&Task{
+			Url:    link,
+			Method: "GET",
+		})
+	}
+}
+
+// Allowed returns whether link is in the scope of the crawl rules
+//	depth is not checked
+func (c *Crawler) Allowed(link string) bool {
+	u, err := url.Parse(link)
+	if err != nil {
+		return false
+	}
+	if len(c.rules.AllowedDomains) > 0 && !matchDomain(u.Hostname(), c.rules.AllowedDomains) {
+		return false
+	}
+	for _, re := range c.exclude {
+		if re.MatchString(link) {
+			return false
+		}
+	}
+	if len(c.include) == 0 {
+		return true
+	}
+	for _, re := range c.include {
+		if re.MatchString(link) {
+			return true
+		}
+	}
+	return false
+}
+
+// follow add the links of the response to the queue
+func (c *Crawler) follow(ctx *Context) {
+	if !strings.Contains(strings.ToLower(ctx.contentType()), "html") {
+		return
+	}
+	var (
+		depth   int
+		session string
+		header  *http.Header
+	)
+	if ctx.Task != nil {
+		depth = ctx.Task.Depth
+		session = ctx.Task.Session
+		header = ctx.Task.Header
+	}
+	if c.rules.MaxDepth > 0 && depth >= c.rules.MaxDepth {
+		return
+	}
+
+	referer := ctx.FinalURL().String()
+	for _, link := range ctx.Links() {
+		if !c.Allowed(link) {
+			continue
+		}
+		if _, loaded := c.seen.LoadOrStore(link, true); loaded {
+			continue
+		}
+		h := http.Header{}
+		if header != nil {
+			h = header.Clone()
+		}
+		// credentials of the task are only sent again to the same host
+		if u, err := url.Parse(link); err != nil || !strings.EqualFold(u.Host, ctx.Request.URL.Host) {
+			for _, name := range credentialHeaders {
+				h.Del(name)
+			}
+		}
+		h.Set("Referer", referer)
+		c.queue.Add(&Task{
+			Url:     link,
+			Method:  "GET",
+			Header:  &h,
+			Session: session,
+			Depth:   depth + 1,
+		})
+	}
+}
+
+// matchDomain returns whether host is one of domains or their subdomains
+func matchDomain(host string, domains []string) bool {
+	host = strings.ToLower(host)
+	for _, item := range domains {
+		item = strings.ToLower(strings.TrimPrefix(item, "."))
+		if host == item || strings.HasSuffix(host, "."+item) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,110 @@
+package esme
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func Test_NormalizeURL(t *testing.T) {
+	base, _ := url.Parse("https://www.example.com/news/list.html")
+	cases := map[string]string{
+		"detail.html#top":             "https://www.example.com/news/detail.html",
+		"/a?b=1":                      "https://www.example.com/a?b=1",
+		"HTTPS://WWW.Example.com:443": "https://www.example.com/",
+		"//cdn.example.com/x.js":      "https://cdn.example.com/x.js",
+		"javascript:void(0)":          "",
+		"mailto:sam@example.com":      "",
+		"#":                           "",
+	}
+	for ref, want := range cases {
+		got, _ := NormalizeURL(base, ref)
+		if got != want {
+			t.Errorf("%s: got %s want %s", ref, got, want)
+		}
+	}
+}
+
+func Test_Crawler(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		switch r.URL.Path {
+		case "/":
+			_, _ = fmt.Fprint(w, `<a href="/a">a</a><a href="/b#x">b</a><a href="/logout">logout</a>
+<a href="https://other.example.com/">other</a><meta http-equiv="refresh" content="0; url=/c">`)
+		case "/a":
+			_, _ = fmt.Fprint(w, `<a href="/">home</a><a href="/a/deep">deep</a><img srcset="/img1.png 1x, /img2.png 2x">`)
+		case "/a/deep":
+			_, _ = fmt.Fprint(w, `<a href="/too-deep">too deep</a>`)
+		default:
+			_, _ = fmt.Fprint(w, `ok`)
+		}
+	}))
+	defer ts.Close()
+
+	var (
+		mux     sync.Mutex
+		crawled = make([]string, 0)
+	)
+	crawler, err := NewCrawler("crawler", 2, NewMemQueue(), JobOptions{
+		SucceedFunc: func(ctx *Context) {
+			mux.Lock()
+			defer mux.Unlock()
+			crawled = append(crawled, ctx.FinalURL().Path)
+		},
+	}, CrawlRules{
+		AllowedDomains: []string{"127.0.0.1"},
+		Exclude:        []string{`/logout`},
+		MaxDepth:       2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	crawler.AddSeed(ts.URL)
+	crawler.Do()
+
+	sort.Strings(crawled)
+	want := []string{"/", "/a", "/a/deep", "/b", "/c", "/img1.png", "/img2.png"}
+	if fmt.Sprint(crawled) != fmt.Sprint(want) {
+		t.Fatalf("crawled: got %v want %v", crawled, want)
+	}
+}
+
+func Test_CrawlerCredentials(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = fmt.Fprint(w, `<a href="/a">a</a><a href="https://other.example.com/">other</a>`)
+	}))
+	defer ts.Close()
+
+	queue := NewMemQueue()
+	crawler, err := NewCrawler("credentials", 1, queue, JobOptions{}, CrawlRules{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := http.Header{"Authorization": {"Bearer secret"}, "Cookie": {"sid=secret"}, "Accept": {"text/html"}}
+	task := &Task{Url: ts.URL, Method: "GET", Header: &header}
+	ctx := HttpGet(task.Url, task)
+	if err = ctx.Do(); err != nil {
+		t.Fatal(err)
+	}
+	crawler.follow(ctx)
+
+	n := 0
+	for task = queue.Pop(); task != nil; task = queue.Pop() {
+		n++
+		h := *task.Header
+		same := strings.HasPrefix(task.Url, ts.URL)
+		if (h.Get("Authorization") != "") != same || (h.Get("Cookie") != "") != same || h.Get("Accept") != "text/html" {
+			t.Fatalf("%s: header %v", task.Url, h)
+		}
+	}
+	if n != 2 {
+		t.Fatalf("links: got %d", n)
+	}
+}
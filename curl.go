@@ -0,0 +1,512 @@
+/*
+curl.go
+import tasks from "Copy as cURL" commands and export requests as curl commands
+*/
+
+package esme
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// ParseCurl returns the Task of a curl command
+//	supports -X -H -d --data-raw --data-binary --data-urlencode -F -b -u -A -e -G -I
+//	--compressed and -x, other options are ignored
+func ParseCurl(cmd string) (*Task, error) {
+	args, err := splitShellWords(cmd)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 0 || args[0] != "curl" {
+		return nil, errors.New("not a curl command")
+	}
+
+	var (
+		task   = &Task{}
+		header = http.Header{}
+		data   = make([]string, 0)
+		form   = NewMultipartForm()
+		method string
+		getArg bool
+	)
+
+	// options followed by a value
+	withValue := map[string]bool{
+		"-X": true, "--request": true, "-H": true, "--header": true,
+		"-d": true, "--data": true, "--data-raw": true, "--data-binary": true, "--data-ascii": true, "--data-urlencode": true,
+		"-F": true, "--form": true, "--form-string": true, "-b": true, "--cookie": true, "-u": true, "--user": true,
+		"-A": true, "--user-agent": true, "-e": true, "--referer": true, "-x": true, "--proxy": true, "--url": true,
+		"-o": true, "--output": true, "-m": true, "--max-time": true, "--connect-timeout": true, "-w": true, "--write-out": true,
+		"--resolve": true, "-T": true, "--upload-file": true, "-c": true, "--cookie-jar": true,
+	}
+
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		name, value, inline := arg, "", false
+
+		// --data=xxx and -dxxx
+		if strings.HasPrefix(arg, "--") {
+			if j := strings.Index(arg, "="); j > 0 && withValue[arg[:j]] {
+				name, value, inline = arg[:j], arg[j+1:], true
+			}
+		} else if strings.HasPrefix(arg, "-") && len(arg) > 2 {
+			if withValue[arg[:2]] {
+				name, value, inline = arg[:2], arg[2:], true
+			} else {
+				// combined flags like -sSL, a trailing option may take a value
+				last := "-" + arg[len(arg)-1:]
+				if withValue[last] {
+					name = last
+				} else {
+					continue
+				}
+			}
+		}
+
+		if withValue[name] && !inline {
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("curl option %s requires a value", name)
+			}
+			i++
+			value = args[i]
+		}
+
+		switch name {
+		case "-X", "--request":
+			method = strings.ToUpper(value)
+		case "-H", "--header":
+			kvs := strings.SplitN(value, ":", 2)
+			if len(kvs) == 2 {
+				header.Add(strings.TrimSpace(kvs[0]), strings.TrimSpace(kvs[1]))
+			}
+		case "-d", "--data", "--data-ascii", "--data-binary", "--data-raw":
+			if strings.HasPrefix(value, "@") && name != "--data-raw" {
+				b, err := ioutil.ReadFile(value[1:])
+				if err != nil {
+					return nil, err
+				}
+				value = string(b)
+				if name != "--data-binary" {
+					value = strings.NewReplacer("\r", "", "\n", "").Replace(value)
+				}
+			}
+			data = append(data, value)
+		case "--data-urlencode":
+			data = append(data, curlURLEncode(value))
+		case "-F", "--form", "--form-string":
+			if err = addCurlForm(form, value, name == "--form-string"); err != nil {
+				return nil, err
+			}
+		case "-b", "--cookie":
+			if strings.Contains(value, "=") {
+				header.Add("Cookie", value)
+			}
+		case "-u", "--user":
+			header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(value)))
+		case "-A", "--user-agent":
+			header.Set("User-Agent", value)
+		case "-e", "--referer":
+			header.Set("Referer", value)
+		case "-x", "--proxy":
+			task.Proxy = value
+		case "--url":
+			task.Url = value
+		case "--compressed":
+			if header.Get("Accept-Encoding") == "" {
+				header.Set("Accept-Encoding", "gzip")
+			}
+		case "-G", "--get":
+			getArg = true
+		case "-I", "--head":
+			method = "HEAD"
+		default:
+			if !strings.HasPrefix(arg, "-") && task.Url == "" {
+				task.Url = arg
+			}
+		}
+	}
+
+	if task.Url == "" {
+		return nil, errors.New("curl command without url")
+	}
+
+	body := strings.Join(data, "&")
+	switch {
+	case getArg && body != "":
+		sep := "?"
+		if strings.Contains(task.Url, "?") {
+			sep = "&"
+		}
+		task.Url += sep + body
+	case len(form.Parts) > 0:
+		task.Multipart = form
+		header.Del("Content-Type")
+	case body != "":
+		task.Payload = []byte(body)
+		if header.Get("Content-Type") == "" {
+			header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	}
+
+	if method == "" {
+		method = "GET"
+		if !getArg && (body != "" || len(form.Parts) > 0) {
+			method = "POST"
+		}
+	}
+	task.Method = method
+	task.Header = &header
+	return task, nil
+}
+
+// ToCurl returns the task as a curl command
+func (t *Task) ToCurl() string {
+	header := http.Header{}
+	if t.Header != nil {
+		header = t.Header.Clone()
+	}
+	var body []byte
+	if t.Multipart != nil {
+		header.Del("Content-Type")
+	} else if len(t.FormData) > 0 {
+		body = []byte(formDataValues(t.FormData).Encode())
+		if header.Get("Content-Type") == "" {
+			header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	} else if len(t.JSON) > 0 {
+		body = t.JSON
+		header.Set("Content-Type", jsonContentType)
+	} else if len(t.Payload) > 0 {
+		body = t.Payload
+	}
+	method := t.Method
+	if method == "" {
+		method = "GET"
+	}
+	cmd := buildCurl(method, t.Url, header, body, t.Proxy)
+	if t.Multipart != nil {
+		cmd += curlFormArgs(t.Multipart)
+	}
+	return cmd
+}
+
+// ToCurl returns the request of the context as a curl command
+//	used to reproduce a failed request outside Go
+func (c *Context) ToCurl() string {
+	if c.Request == nil {
+		return ""
+	}
+	body, _ := readRequestBody(c.Request)
+	return buildCurl(c.Request.Method, c.Request.URL.String(), c.Request.Header, body, c.proxy)
+}
+
+/*
+private
+*/
+
+func buildCurl(method, rawURL string, header http.Header, body []byte, proxy string) string {
+	parts := []string{"curl"}
+	if method != "GET" || len(body) > 0 {
+		parts = append(parts, "-X", method)
+	}
+	parts = append(parts, shellQuote(rawURL))
+
+	keys := make([]string, 0, len(header))
+	for key := range header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, value := range header[key] {
+			parts = append(parts, "-H", shellQuote(key+": "+value))
+		}
+	}
+	if len(body) > 0 {
+		parts = append(parts, "--data-raw", shellQuote(string(body)))
+	}
+	if strings.Contains(header.Get("Accept-Encoding"), "gzip") {
+		parts = append(parts, "--compressed")
+	}
+	if proxy != "" {
+		parts = append(parts, "-x", shellQuote(proxy))
+	}
+	return strings.Join(parts, " ")
+}
+
+// shellQuote quote s for a POSIX shell
+//	strings with control characters use $'...'
+func shellQuote(s string) string {
+	needANSI := false
+	for _, r := range s {
+		if r < 0x20 || r == 0x7f {
+			needANSI = true
+			break
+		}
+	}
+	if !needANSI {
+		return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+	}
+	var buf strings.Builder
+	buf.WriteString("$'")
+	for _, r := range s {
+		switch r {
+		case '\\':
+			buf.WriteString(`\\`)
+		case '\'':
+			buf.WriteString(`\'`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\t':
+			buf.WriteString(`\t`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&buf, `\x%02x`, r)
+			} else {
+				buf.WriteRune(r)
+			}
+		}
+	}
+	buf.WriteString("'")
+	return buf.String()
+}
+
+// splitShellWords split a command line like a POSIX shell
+//	supports '...', "...", $'...', backslash escapes and line continuations
+func splitShellWords(s string) ([]string, error) {
+	words := make([]string, 0)
+	var (
+		buf    strings.Builder
+		inWord bool
+	)
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			if inWord {
+				words = append(words, buf.String())
+				buf.Reset()
+				inWord = false
+			}
+		case r == '\\':
+			inWord = true
+			if i+1 < len(runes) {
+				i++
+				if runes[i] == '\n' {
+					continue
+				}
+				if runes[i] == '\r' && i+1 < len(runes) && runes[i+1] == '\n' {
+					i++
+					continue
+				}
+				buf.WriteRune(runes[i])
+			}
+		case r == '\'':
+			inWord = true
+			j := i + 1
+			for j < len(runes) && runes[j] != '\'' {
+				j++
+			}
+			if j >= len(runes) {
+				return nil, errors.New("unterminated single quote")
+			}
+			buf.WriteString(string(runes[i+1 : j]))
+			i = j
+		case r == '$' && i+1 < len(runes) && runes[i+1] == '\'':
+			inWord = true
+			j, err := readANSIQuoted(runes, i+2, &buf)
+			if err != nil {
+				return nil, err
+			}
+			i = j
+		case r == '"':
+			inWord = true
+			j := i + 1
+			for ; j < len(runes) && runes[j] != '"'; j++ {
+				if runes[j] == '\\' && j+1 < len(runes) && strings.ContainsRune("$`\"\\\n", runes[j+1]) {
+					j++
+					if runes[j] == '\n' {
+						continue
+					}
+				}
+				buf.WriteRune(runes[j])
+			}
+			if j >= len(runes) {
+				return nil, errors.New("unterminated double quote")
+			}
+			i = j
+		default:
+			inWord = true
+			buf.WriteRune(r)
+		}
+	}
+	if inWord {
+		words = append(words, buf.String())
+	}
+	return words, nil
+}
+
+// readANSIQuoted read a $'...' string starting after the quote
+//	returns the index of the closing quote
+func readANSIQuoted(runes []rune, i int, buf *strings.Builder) (int, error) {
+	escapes := map[rune]string{'n': "\n", 'r': "\r", 't': "\t", '\\': "\\", '\'': "'", '"': "\"", 'a': "\a", 'b': "\b", 'e': "\x1b", 'f': "\f", 'v': "\v"}
+	var raw []byte
+	flush := func() {
+		if len(raw) > 0 {
+			buf.Write(raw)
+			raw = raw[:0]
+		}
+	}
+	for ; i < len(runes); i++ {
+		r := runes[i]
+		if r == '\'' {
+			flush()
+			return i, nil
+		}
+		if r != '\\' || i+1 >= len(runes) {
+			flush()
+			buf.WriteRune(r)
+			continue
+		}
+		i++
+		if v, ok := escapes[runes[i]]; ok {
+			flush()
+			buf.WriteString(v)
+			continue
+		}
+		switch runes[i] {
+		case 'x':
+			n, size := parseHexRunes(runes[i+1:], 2)
+			if size == 0 {
+				flush()
+				buf.WriteString(`\x`)
+				continue
+			}
+			raw = append(raw, byte(n))
+			i += size
+		case 'u', 'U':
+			max := 4
+			if runes[i] == 'U' {
+				max = 8
+			}
+			n, size := parseHexRunes(runes[i+1:], max)
+			flush()
+			buf.WriteRune(rune(n))
+			i += size
+		default:
+			flush()
+			buf.WriteRune('\\')
+			buf.WriteRune(runes[i])
+		}
+	}
+	return 0, errors.New("unterminated $' quote")
+}
+
+func parseHexRunes(runes []rune, max int) (int, int) {
+	n, size := 0, 0
+	for size < max && size < len(runes) {
+		r := runes[size]
+		var v int
+		switch {
+		case r >= '0' && r <= '9':
+			v = int(r - '0')
+		case r >= 'a' && r <= 'f':
+			v = int(r-'a') + 10
+		case r >= 'A' && r <= 'F':
+			v = int(r-'A') + 10
+		default:
+			return n, size
+		}
+		n = n*16 + v
+		size++
+	}
+	return n, size
+}
+
+// curlURLEncode encode a --data-urlencode value
+//	name=content encodes the content, content alone is encoded entirely
+func curlURLEncode(s string) string {
+	if i := strings.Index(s, "="); i > 0 {
+		return s[:i+1] + url.QueryEscape(s[i+1:])
+	}
+	return url.QueryEscape(strings.TrimPrefix(s, "="))
+}
+
+// addCurlForm add a -F value to the form
+//	name=value, name=@file;type=mime;filename=name and name=<file
+func addCurlForm(form *MultipartForm, item string, literal bool) error {
+	kvs := strings.SplitN(item, "=", 2)
+	if len(kvs) != 2 {
+		return fmt.Errorf("invalid curl form: %s", item)
+	}
+	name, value := kvs[0], kvs[1]
+	if literal || (!strings.HasPrefix(value, "@") && !strings.HasPrefix(value, "<")) {
+		form.AddField(name, value)
+		return nil
+	}
+
+	params := strings.Split(value[1:], ";")
+	part := &MultipartPart{Name: name, FilePath: params[0]}
+	for _, p := range params[1:] {
+		switch {
+		case strings.HasPrefix(p, "type="):
+			part.ContentType = p[5:]
+		case strings.HasPrefix(p, "filename="):
+			part.FileName = strings.Trim(p[9:], `"`)
+		}
+	}
+
+	// <file sends the content as a text field
+	if value[0] == '<' {
+		b, err := ioutil.ReadFile(part.FilePath)
+		if err != nil {
+			return err
+		}
+		part.Value, part.FilePath, part.FileName = string(b), "", ""
+	}
+	form.AddPart(part)
+	return nil
+}
+
+// curlFormArgs returns the -F options of the form
+//	parts read from an io.Reader can not be exported
+func curlFormArgs(form *MultipartForm) string {
+	var buf strings.Builder
+	for _, part := range form.Parts {
+		switch {
+		case part.FilePath != "":
+			value := part.Name + "=@" + part.FilePath
+			if part.ContentType != "" {
+				value += ";type=" + part.ContentType
+			}
+			if part.FileName != "" {
+				value += ";filename=" + part.FileName
+			}
+			buf.WriteString(" -F " + shellQuote(value))
+		case part.Reader == nil:
+			buf.WriteString(" --form-string " + shellQuote(part.Name+"="+part.Value))
+		}
+	}
+	return buf.String()
+}
+
+func escapeQuotes(s string) string {
+	return strings.NewReplacer("\\", "\\\\", `"`, "\\\"").Replace(s)
+}
+
+func formDataValues(f FormData) url.Values {
+	values := url.Values{}
+	for k, v := range f {
+		values.Set(k, v)
+	}
+	return values
+}
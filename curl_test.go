@@ -0,0 +1,107 @@
+package esme
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func Test_ParseCurl(t *testing.T) {
+	cmd := `curl 'https://example.com/api?id=1' \
+  -H 'accept: application/json' \
+  -H "x-token: a\"b" \
+  -b 'sid=1; uid=2' \
+  --data-raw $'{"name":"it\'s\\n"}' \
+  --compressed -u user:pass -x http://127.0.0.1:8888`
+	task, err := ParseCurl(cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.Method != "POST" || task.Url != "https://example.com/api?id=1" {
+		t.Fatalf("method %s url %s", task.Method, task.Url)
+	}
+	h := *task.Header
+	if h.Get("Accept") != "application/json" || h.Get("X-Token") != `a"b` || h.Get("Cookie") != "sid=1; uid=2" {
+		t.Fatalf("header %v", h)
+	}
+	if h.Get("Authorization") != "Basic dXNlcjpwYXNz" || h.Get("Accept-Encoding") != "gzip" {
+		t.Fatalf("header %v", h)
+	}
+	if string(task.Payload) != `{"name":"it's\n"}` {
+		t.Fatalf("payload %q", task.Payload)
+	}
+	if task.Proxy != "http://127.0.0.1:8888" {
+		t.Fatalf("proxy %s", task.Proxy)
+	}
+
+	// round trip
+	again, err := ParseCurl(task.ToCurl())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Method != task.Method || again.Url != task.Url || string(again.Payload) != string(task.Payload) ||
+		again.Proxy != task.Proxy || again.Header.Get("X-Token") != `a"b` {
+		t.Fatalf("round trip: %s", task.ToCurl())
+	}
+
+	task, err = ParseCurl(`curl -G -d a=1 --data-urlencode "q=a b" -XDELETE http://example.com/s`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.Method != "DELETE" || task.Url != "http://example.com/s?a=1&q=a+b" || len(task.Payload) != 0 {
+		t.Fatalf("method %s url %s", task.Method, task.Url)
+	}
+
+	if _, err = ParseCurl(`curl 'http://example.com`); err == nil {
+		t.Fatal("unterminated quote should fail")
+	}
+	if _, err = ParseCurl(`wget http://example.com`); err == nil {
+		t.Fatal("not a curl command should fail")
+	}
+}
+
+func Test_ParseCurlForm(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "a.txt")
+	if err := ioutil.WriteFile(file, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	task, err := ParseCurl(`curl -F name=esme -F 'file=@` + file + `;type=text/plain' http://example.com/upload`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.Method != "POST" {
+		t.Fatalf("method %s", task.Method)
+	}
+	parts := task.Multipart.Parts
+	if len(parts) != 2 || parts[0].Value != "esme" || parts[1].FilePath != file || parts[1].ContentType != "text/plain" {
+		t.Fatalf("form %v", parts)
+	}
+
+	again, err := ParseCurl(task.ToCurl())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(again.Multipart.Parts) != 2 || again.Multipart.Parts[1].FilePath != file || again.Header.Get("Content-Type") != "" {
+		t.Fatalf("round trip: %s", task.ToCurl())
+	}
+}
+
+func Test_ContextToCurl(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		_, _ = w.Write(body)
+	}))
+	defer ts.Close()
+
+	ctx := HttpPost(ts.URL+"/post", FormData{"a": "1"}, Header{"X-Id": "7"})
+	ctx.Do()
+	want := `curl -X POST '` + ts.URL + `/post' -H 'Content-Type: application/x-www-form-urlencoded' -H 'User-Agent: ` + defaultUserAgent + `' -H 'X-Id: 7' --data-raw 'a=1'`
+	if got := ctx.ToCurl(); got != want {
+		t.Fatalf("got %s\nwant %s", got, want)
+	}
+	if ctx.ToString() != "a=1" {
+		t.Fatalf("body %s", ctx.ToString())
+	}
+}
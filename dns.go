@@ -0,0 +1,492 @@
+/*
+dns.go
+dns resolver with cache, host overrides, dns servers and DNS over HTTPS
+*/
+
+package esme
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+
+	// defaultDNSTTL how long lookups are cached by default
+	defaultDNSTTL = 5 * time.Minute
+
+	// dnsTimeout timeout of a query to a dns server
+	dnsTimeout = 5 * time.Second
+
+	// dialTimeout timeout of dialing all the ips of a host
+	dialTimeout = 30 * time.Second
+
+	// minDialTimeout minimum timeout of dialing one ip
+	minDialTimeout = 2 * time.Second
+
+	// defaultFallbackDelay delay before the other ip version is dialed, like net.Dialer
+	defaultFallbackDelay = 300 * time.Millisecond
+)
+
+// IPPreference which ip versions are dialed, and in which order
+type IPPreference string
+
+const (
+
+	// PreferAny dial the addresses in the order of the answer
+	PreferAny IPPreference = ""
+
+	// PreferIPv4 dial ipv4 addresses first
+	PreferIPv4 IPPreference = "ipv4"
+
+	// PreferIPv6 dial ipv6 addresses first
+	PreferIPv6 IPPreference = "ipv6"
+
+	// OnlyIPv4 dial ipv4 addresses only
+	OnlyIPv4 IPPreference = "ipv4only"
+
+	// OnlyIPv6 dial ipv6 addresses only
+	OnlyIPv6 IPPreference = "ipv6only"
+)
+
+// Resolver resolve the hosts of requests
+//	share one *Resolver between contexts to share its cache,
+//	the zero value uses the system resolver with a 5 minutes cache
+type Resolver struct {
+
+	// Hosts static addresses of hosts, like curl --resolve
+	//	key is "host" or "host:port", value is one or more ips separated by ","
+	//	with a proxy the target host is resolved by the proxy, Hosts only applies to the proxy
+	Hosts map[string]string
+
+	// Servers dns servers, like "8.8.8.8:53", the system resolver when empty
+	Servers []string
+
+	// DoH url of a DNS over HTTPS server (RFC 8484), like "https://1.1.1.1/dns-query"
+	//	used instead of Servers when set
+	DoH string
+
+	// Client client of the DoH queries, http.DefaultClient by default
+	//	its transport must not use this resolver
+	Client *http.Client
+
+	// TTL how long lookups are cached, 5 minutes by default, negative disables the cache
+	TTL time.Duration
+
+	// Prefer which ip versions are dialed
+	Prefer IPPreference
+
+	// FallbackDelay how long the ips of the first version are dialed alone
+	//	before the other version is dialed in parallel (Happy Eyeballs),
+	//	300ms by default, negative dials all the ips in order
+	FallbackDelay time.Duration
+
+	mux sync.Mutex
+
+	cache map[string]*dnsEntry
+
+	// next index of the next dns server
+	next uint32
+}
+
+type dnsEntry struct {
+	ips     []net.IP
+	expires time.Time
+}
+
+// SetResolver set the dns resolver of the request
+func (c *Context) SetResolver(r *Resolver) *Context {
+	if r == nil {
+		return c
+	}
+	c.resolver = r
+	if transport, ok := c.client.Transport.(*http.Transport); ok {
+		c.configureTransport(transport)
+	}
+	return c
+}
+
+// LookupIP returns the ips of host, ordered by Prefer
+func (r *Resolver) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	ips, err := r.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	ips = r.sort(ips)
+	if len(ips) == 0 {
+		return nil, &net.DNSError{Err: fmt.Sprintf("no %s address", r.Prefer), Name: host, IsNotFound: true}
+	}
+	return ips, nil
+}
+
+// DialContext dial address with the ips of the resolver
+//	used as http.Transport.DialContext. the ips of the version of the first one are tried in order,
+//	the ips of the other version after FallbackDelay, each ip gets a part of the timeout
+func (r *Resolver) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IP{ip}
+	} else if ips = r.override(host, port); ips == nil {
+		trace := httptrace.ContextClientTrace(ctx)
+		if trace != nil && trace.DNSStart != nil {
+			trace.DNSStart(httptrace.DNSStartInfo{Host: host})
+		}
+		ips, err = r.LookupIP(ctx, host)
+		if trace != nil && trace.DNSDone != nil {
+			addrs := make([]net.IPAddr, 0, len(ips))
+			for _, ip := range ips {
+				addrs = append(addrs, net.IPAddr{IP: ip})
+			}
+			trace.DNSDone(httptrace.DNSDoneInfo{Addrs: addrs, Err: err})
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, dialTimeout)
+	defer cancel()
+	primaries, fallbacks := partitionIPs(ips)
+	if len(fallbacks) == 0 || r.FallbackDelay < 0 {
+		return dialSerial(ctx, network, port, ips)
+	}
+	return r.dialParallel(ctx, network, port, primaries, fallbacks)
+}
+
+// Clear clear the cache of the resolver
+func (r *Resolver) Clear() {
+	r.mux.Lock()
+	r.cache = nil
+	r.mux.Unlock()
+}
+
+/*
+private
+*/
+
+// partitionIPs split ips into those of the version of the first one and the others
+func partitionIPs(ips []net.IP) (primaries, fallbacks []net.IP) {
+	for _, ip := range ips {
+		if (ip.To4() != nil) == (ips[0].To4() != nil) {
+			primaries = append(primaries, ip)
+		} else {
+			fallbacks = append(fallbacks, ip)
+		}
+	}
+	return
+}
+
+// dialSerial dial the ips in order, each one gets a part of the remaining time
+//	so an unreachable ip does not use all of it
+func dialSerial(ctx context.Context, network, port string, ips []net.IP) (net.Conn, error) {
+	dialer := &net.Dialer{KeepAlive: 30 * time.Second}
+	deadline, _ := ctx.Deadline()
+	var (
+		conn net.Conn
+		err  error
+	)
+	for i, ip := range ips {
+		timeout := time.Until(deadline) / time.Duration(len(ips)-i)
+		if timeout < minDialTimeout {
+			timeout = minDialTimeout
+		}
+		dialCtx, cancel := context.WithTimeout(ctx, timeout)
+		conn, err = dialer.DialContext(dialCtx, network, net.JoinHostPort(ip.String(), port))
+		cancel()
+		if err == nil {
+			return conn, nil
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, err
+}
+
+// dialParallel dial the primaries, and the fallbacks after FallbackDelay or when the primaries failed
+//	the first connection is returned, the other one is closed
+func (r *Resolver) dialParallel(ctx context.Context, network, port string, primaries, fallbacks []net.IP) (net.Conn, error) {
+	type dialResult struct {
+		conn    net.Conn
+		err     error
+		primary bool
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan dialResult)
+	returned := make(chan struct{})
+	defer close(returned)
+	dial := func(ips []net.IP, primary bool) {
+		conn, err := dialSerial(ctx, network, port, ips)
+		select {
+		case results <- dialResult{conn: conn, err: err, primary: primary}:
+		case <-returned:
+			if conn != nil {
+				_ = conn.Close()
+			}
+		}
+	}
+
+	delay := r.FallbackDelay
+	if delay == 0 {
+		delay = defaultFallbackDelay
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	go dial(primaries, true)
+	started, done := 1, 0
+	var primaryErr error
+	for {
+		select {
+		case <-timer.C:
+			if started == 1 {
+				started++
+				go dial(fallbacks, false)
+			}
+		case res := <-results:
+			done++
+			if res.err == nil {
+				return res.conn, nil
+			}
+			if res.primary {
+				primaryErr = res.err
+			}
+			if started == 1 {
+				// the primaries failed before the delay
+				started++
+				go dial(fallbacks, false)
+				continue
+			}
+			if done == started {
+				return nil, primaryErr
+			}
+		}
+	}
+}
+
+// override returns the static ips of host, nil when it has none
+func (r *Resolver) override(host, port string) []net.IP {
+	if len(r.Hosts) == 0 {
+		return nil
+	}
+	value, ok := r.Hosts[net.JoinHostPort(host, port)]
+	if !ok {
+		if value, ok = r.Hosts[host]; !ok {
+			return nil
+		}
+	}
+	var ips []net.IP
+	for _, item := range strings.Split(value, ",") {
+		if ip := net.ParseIP(strings.Trim(strings.TrimSpace(item), "[]")); ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+// lookup returns the cached ips of host, or looks them up
+func (r *Resolver) lookup(ctx context.Context, host string) ([]net.IP, error) {
+	now := time.Now()
+	r.mux.Lock()
+	entry := r.cache[host]
+	r.mux.Unlock()
+	if entry != nil && now.Before(entry.expires) {
+		return entry.ips, nil
+	}
+
+	var (
+		ips []net.IP
+		err error
+	)
+	if r.DoH != "" {
+		ips, err = r.lookupDoH(ctx, host)
+	} else {
+		ips, err = r.netResolver().LookupIP(ctx, r.network(), host)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := r.TTL
+	if ttl == 0 {
+		ttl = defaultDNSTTL
+	}
+	if ttl > 0 {
+		r.mux.Lock()
+		if r.cache == nil {
+			r.cache = make(map[string]*dnsEntry)
+		}
+		r.cache[host] = &dnsEntry{ips: ips, expires: now.Add(ttl)}
+		r.mux.Unlock()
+	}
+	return ips, nil
+}
+
+// network returns the network of LookupIP
+func (r *Resolver) network() string {
+	switch r.Prefer {
+	case OnlyIPv4:
+		return "ip4"
+	case OnlyIPv6:
+		return "ip6"
+	}
+	return "ip"
+}
+
+// netResolver returns the resolver querying Servers
+func (r *Resolver) netResolver() *net.Resolver {
+	if len(r.Servers) == 0 {
+		return net.DefaultResolver
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			i := atomic.AddUint32(&r.next, 1)
+			server := r.Servers[int(i)%len(r.Servers)]
+			if _, _, err := net.SplitHostPort(server); err != nil {
+				server = net.JoinHostPort(server, "53")
+			}
+			d := net.Dialer{Timeout: dnsTimeout}
+			return d.DialContext(ctx, network, server)
+		},
+	}
+}
+
+// sort returns the ips allowed by Prefer, preferred first
+func (r *Resolver) sort(ips []net.IP) []net.IP {
+	if r.Prefer == PreferAny {
+		return ips
+	}
+	var v4, v6 []net.IP
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			v4 = append(v4, ip)
+		} else {
+			v6 = append(v6, ip)
+		}
+	}
+	switch r.Prefer {
+	case PreferIPv4:
+		return append(v4, v6...)
+	case PreferIPv6:
+		return append(v6, v4...)
+	case OnlyIPv4:
+		return v4
+	case OnlyIPv6:
+		return v6
+	}
+	return ips
+}
+
+// lookupDoH query the A and AAAA records of host with DNS over HTTPS
+func (r *Resolver) lookupDoH(ctx context.Context, host string) ([]net.IP, error) {
+	var types []dnsmessage.Type
+	if r.Prefer != OnlyIPv6 {
+		types = append(types, dnsmessage.TypeA)
+	}
+	if r.Prefer != OnlyIPv4 {
+		types = append(types, dnsmessage.TypeAAAA)
+	}
+
+	var (
+		ips     []net.IP
+		lastErr error
+	)
+	for _, t := range types {
+		items, err := r.queryDoH(ctx, host, t)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		ips = append(ips, items...)
+	}
+	if len(ips) == 0 {
+		if lastErr == nil {
+			lastErr = &net.DNSError{Err: "no such host", Name: host, Server: r.DoH, IsNotFound: true}
+		}
+		return nil, lastErr
+	}
+	return ips, nil
+}
+
+// queryDoH send one query of type t to the DoH server
+func (r *Resolver) queryDoH(ctx context.Context, host string, t dnsmessage.Type) ([]net.IP, error) {
+	dnsErr := func(err error) error {
+		return &net.DNSError{Err: err.Error(), Name: host, Server: r.DoH}
+	}
+	name, err := dnsmessage.NewName(strings.TrimSuffix(host, ".") + ".")
+	if err != nil {
+		return nil, dnsErr(err)
+	}
+	query := dnsmessage.Message{
+		Header:    dnsmessage.Header{RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: name, Type: t, Class: dnsmessage.ClassINET}},
+	}
+	packet, err := query.Pack()
+	if err != nil {
+		return nil, dnsErr(err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, dnsTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.DoH, bytes.NewReader(packet))
+	if err != nil {
+		return nil, dnsErr(err)
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, dnsErr(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, dnsErr(fmt.Errorf("doh server returned %s", resp.Status))
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, dnsErr(err)
+	}
+
+	var answer dnsmessage.Message
+	if err = answer.Unpack(body); err != nil {
+		return nil, dnsErr(err)
+	}
+	if answer.RCode == dnsmessage.RCodeNameError {
+		return nil, &net.DNSError{Err: "no such host", Name: host, Server: r.DoH, IsNotFound: true}
+	}
+	if answer.RCode != dnsmessage.RCodeSuccess {
+		return nil, dnsErr(fmt.Errorf("doh server returned %s", answer.RCode))
+	}
+	var ips []net.IP
+	for _, item := range answer.Answers {
+		switch rr := item.Body.(type) {
+		case *dnsmessage.AResource:
+			ips = append(ips, net.IP(rr.A[:]))
+		case *dnsmessage.AAAAResource:
+			ips = append(ips, net.IP(rr.AAAA[:]))
+		}
+	}
+	return ips, nil
+}
@@ -0,0 +1,203 @@
+package esme
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dnsAnswer answer the query with 127.0.0.1 for A questions of host
+func dnsAnswer(t *testing.T, query []byte, host string) []byte {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(query); err != nil {
+		t.Errorf("unpack: %v", err)
+		return nil
+	}
+	msg.Header.Response = true
+	q := msg.Questions[0]
+	if q.Name.String() != host+"." {
+		msg.Header.RCode = dnsmessage.RCodeNameError
+	} else if q.Type == dnsmessage.TypeA {
+		msg.Answers = []dnsmessage.Resource{{
+			Header: dnsmessage.ResourceHeader{Name: q.Name, Type: q.Type, Class: q.Class, TTL: 60},
+			Body:   &dnsmessage.AResource{A: [4]byte{127, 0, 0, 1}},
+		}}
+	}
+	b, err := msg.Pack()
+	if err != nil {
+		t.Errorf("pack: %v", err)
+	}
+	return b
+}
+
+func Test_ResolverHosts(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Host))
+	}))
+	defer ts.Close()
+	_, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+
+	r := &Resolver{Hosts: map[string]string{"staging.example.test:" + port: "127.0.0.1"}}
+	ctx := HttpGet("http://staging.example.test:" + port + "/").SetResolver(r)
+	if err := ctx.Do(); err != nil || ctx.ToString() != "staging.example.test:"+port {
+		t.Fatalf("err %v body %s", err, ctx.ToString())
+	}
+
+	// other hosts are looked up, .test never resolves
+	ctx = HttpGet("http://other.example.test:" + port + "/").SetResolver(r)
+	err := ctx.Do()
+	var reqErr *RequestError
+	if !errors.As(err, &reqErr) || reqErr.Kind != ErrorDNS {
+		t.Fatalf("err %v", err)
+	}
+}
+
+func Test_ResolverDoH(t *testing.T) {
+	var queries int32
+	doh := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&queries, 1)
+		query, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/dns-message")
+		_, _ = w.Write(dnsAnswer(t, query, "api.example.test"))
+	}))
+	defer doh.Close()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+	_, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+
+	r := &Resolver{DoH: doh.URL, Prefer: PreferIPv4}
+	for i := 0; i < 2; i++ {
+		ctx := HttpGet("http://api.example.test:" + port + "/").SetResolver(r)
+		if err := ctx.Do(); err != nil || ctx.ToString() != "ok" {
+			t.Fatalf("err %v body %s", err, ctx.ToString())
+		}
+		if ctx.Timings().DNS <= 0 {
+			t.Fatalf("timings %v", ctx.Timings())
+		}
+	}
+	// A and AAAA queried once, then cached
+	if n := atomic.LoadInt32(&queries); n != 2 {
+		t.Fatalf("queries %d", n)
+	}
+
+	r.Clear()
+	_, err := r.LookupIP(context.Background(), "missing.example.test")
+	var dnsErr *net.DNSError
+	if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+		t.Fatalf("err %v", err)
+	}
+
+	r = &Resolver{DoH: doh.URL, Prefer: OnlyIPv6}
+	if _, err = r.LookupIP(context.Background(), "api.example.test"); !errors.As(err, &dnsErr) {
+		t.Fatalf("err %v", err)
+	}
+}
+
+func Test_ResolverServers(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("udp: %v", err)
+	}
+	defer conn.Close()
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = conn.WriteTo(dnsAnswer(t, buf[:n], "db.example.test"), addr)
+		}
+	}()
+
+	r := &Resolver{Servers: []string{conn.LocalAddr().String()}, TTL: -1}
+	ips, err := r.LookupIP(context.Background(), "db.example.test")
+	if err != nil || len(ips) != 1 || !ips[0].Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Fatalf("ips %v err %v", ips, err)
+	}
+	if r.cache != nil {
+		t.Fatal("negative ttl should not cache")
+	}
+}
+
+func Test_ResolverPrefer(t *testing.T) {
+	ips := []net.IP{net.ParseIP("::1"), net.ParseIP("10.0.0.1"), net.ParseIP("fe80::1")}
+	r := &Resolver{Prefer: PreferIPv4}
+	if got := r.sort(ips); len(got) != 3 || got[0].String() != "10.0.0.1" {
+		t.Fatalf("got %v", got)
+	}
+	r.Prefer = OnlyIPv6
+	if got := r.sort(ips); len(got) != 2 || got[0].String() != "::1" {
+		t.Fatalf("got %v", got)
+	}
+	r.Prefer = OnlyIPv4
+	if got := r.override("host", "80"); got != nil {
+		t.Fatalf("got %v", got)
+	}
+	r.Hosts = map[string]string{"host": "10.0.0.1, [::1]"}
+	if got := r.override("host", "443"); len(got) != 2 || got[1].String() != "::1" {
+		t.Fatalf("got %v", got)
+	}
+}
+
+// countTransport count the requests sent through it
+type countTransport struct {
+	n int32
+}
+
+func (t *countTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	atomic.AddInt32(&t.n, 1)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func Test_ResolverDoHClient(t *testing.T) {
+	doh := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/dns-message")
+		_, _ = w.Write(dnsAnswer(t, query, "api.example.test"))
+	}))
+	defer doh.Close()
+
+	transport := &countTransport{}
+	r := &Resolver{DoH: doh.URL, Prefer: OnlyIPv4, Client: &http.Client{Transport: transport}}
+	if ips, err := r.LookupIP(context.Background(), "api.example.test"); err != nil || len(ips) != 1 {
+		t.Fatalf("ips %v err %v", ips, err)
+	}
+	if n := atomic.LoadInt32(&transport.n); n != 1 {
+		t.Fatalf("queries %d", n)
+	}
+}
+
+func Test_ResolverFallback(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+	_, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+
+	// the ipv6 address does not answer, ipv4 is dialed after FallbackDelay
+	r := &Resolver{Hosts: map[string]string{"dual.example.test": "100::1, 127.0.0.1"}, FallbackDelay: 50 * time.Millisecond}
+	start := time.Now()
+	ctx := HttpGet("http://dual.example.test:" + port + "/").SetResolver(r)
+	if err := ctx.Do(); err != nil || ctx.ToString() != "ok" {
+		t.Fatalf("err %v body %s", err, ctx.ToString())
+	}
+	if d := time.Since(start); d >= minDialTimeout {
+		t.Fatalf("dialed in %s", d)
+	}
+
+	primaries, fallbacks := partitionIPs([]net.IP{net.ParseIP("::1"), net.ParseIP("10.0.0.1"), net.ParseIP("::2")})
+	if len(primaries) != 2 || len(fallbacks) != 1 || !primaries[1].Equal(net.ParseIP("::2")) {
+		t.Fatalf("primaries %v fallbacks %v", primaries, fallbacks)
+	}
+}
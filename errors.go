@@ -0,0 +1,212 @@
+/*
+errors.go
+request outcomes and classified request errors
+*/
+
+package esme
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"strings"
+)
+
+// Outcome result of the request of a Context
+type Outcome string
+
+const (
+
+	// OutcomeNone the request is not executed
+	OutcomeNone Outcome = ""
+
+	// OutcomeSuccess the status code is a success
+	OutcomeSuccess Outcome = "success"
+
+	// OutcomeRetry the status code should be retried
+	OutcomeRetry Outcome = "retry"
+
+	// OutcomeFail the status code is a failure
+	OutcomeFail Outcome = "fail"
+
+	// OutcomeError the request returned an error
+	OutcomeError Outcome = "error"
+
+	// OutcomeCancelled the context of the request was cancelled
+	OutcomeCancelled Outcome = "cancelled"
+)
+
+// ErrorKind class of a request error
+type ErrorKind string
+
+const (
+
+	// ErrorUnknown an error not classified
+	ErrorUnknown ErrorKind = "unknown"
+
+	// ErrorRequest the request can not be created or its body read
+	ErrorRequest ErrorKind = "request"
+
+	// ErrorTimeout the request or the response body timed out
+	ErrorTimeout ErrorKind = "timeout"
+
+	// ErrorDNS the host can not be resolved
+	ErrorDNS ErrorKind = "dns"
+
+	// ErrorTLS tls handshake or certificate verification failed
+	ErrorTLS ErrorKind = "tls"
+
+	// ErrorProxy the connection to the proxy failed
+	ErrorProxy ErrorKind = "proxy"
+
+	// ErrorConnection the connection failed or was reset
+	ErrorConnection ErrorKind = "connection"
+
+	// ErrorRedirect a redirect was refused by the RedirectPolicy
+	ErrorRedirect ErrorKind = "redirect"
+
+	// ErrorDecode the response body can not be decoded
+	ErrorDecode ErrorKind = "decode"
+
+	// ErrorLimit the response exceeds MaxBodySize or its content type is not allowed
+	ErrorLimit ErrorKind = "limit"
+
+	// ErrorAuth the credentials of the request can not be obtained
+	ErrorAuth ErrorKind = "auth"
+
+	// ErrorCancelled the context of the request was cancelled
+	ErrorCancelled ErrorKind = "cancelled"
+)
+
+// RequestError a classified error of a request
+type RequestError struct {
+	Kind ErrorKind
+
+	Err error
+}
+
+// ErrorFunc callback of a request error
+type ErrorFunc func(ctx *Context, err *RequestError)
+
+// Error returns the message of the wrapped error
+func (e *RequestError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the wrapped error
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+// Temporary returns whether the request may succeed when retried
+func (e *RequestError) Temporary() bool {
+	switch e.Kind {
+	case ErrorTimeout, ErrorConnection, ErrorProxy, ErrorDNS:
+		return true
+	}
+	return false
+}
+
+// Outcome returns the result of the last request
+func (c *Context) Outcome() Outcome {
+	return c.outcome
+}
+
+// SetErrorFunc set the callback of request errors
+func (c *Context) SetErrorFunc(fn ErrorFunc) *Context {
+	c.errorFunc = fn
+	return c
+}
+
+// WithContext set the context.Context of the request,
+//	a cancelled context stops the request with OutcomeCancelled
+func (c *Context) WithContext(ctx context.Context) *Context {
+	if ctx == nil || c.Request == nil {
+		return c
+	}
+	c.Request = c.Request.WithContext(ctx)
+	return c
+}
+
+/*
+private
+*/
+
+// newRequestError returns a *RequestError of kind
+func newRequestError(kind ErrorKind, err error) *RequestError {
+	return &RequestError{Kind: kind, Err: err}
+}
+
+// classifyError returns err as a *RequestError
+func classifyError(err error) *RequestError {
+	if err == nil {
+		return nil
+	}
+	var reqErr *RequestError
+	if errors.As(err, &reqErr) {
+		return reqErr
+	}
+	return newRequestError(errorKind(err), err)
+}
+
+func errorKind(err error) ErrorKind {
+	if errors.Is(err, context.Canceled) {
+		return ErrorCancelled
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrorTimeout
+	}
+
+	var (
+		dnsErr      *net.DNSError
+		opErr       *net.OpError
+		unknownCA   x509.UnknownAuthorityError
+		hostnameErr x509.HostnameError
+		invalidCert x509.CertificateInvalidError
+		recordErr   tls.RecordHeaderError
+		netErr      net.Error
+		sizeErr     *BodySizeError
+		typeErr     *ContentTypeError
+		redirectErr *RedirectError
+	)
+	msg := err.Error()
+	switch {
+	case errors.As(err, &sizeErr), errors.As(err, &typeErr):
+		return ErrorLimit
+	case errors.As(err, &redirectErr):
+		return ErrorRedirect
+	case errors.As(err, &opErr) && opErr.Op == "proxyconnect", strings.Contains(msg, "proxyconnect"):
+		return ErrorProxy
+	case errors.As(err, &dnsErr):
+		return ErrorDNS
+	case errors.As(err, &unknownCA), errors.As(err, &hostnameErr), errors.As(err, &invalidCert), errors.As(err, &recordErr),
+		strings.Contains(msg, "tls: "), strings.Contains(msg, "x509: "), strings.Contains(msg, "HTTP response to HTTPS client"):
+		return ErrorTLS
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return ErrorTimeout
+	case errors.As(err, &opErr):
+		return ErrorConnection
+	case strings.Contains(msg, "connection reset"), strings.Contains(msg, "EOF"):
+		return ErrorConnection
+	}
+	return ErrorUnknown
+}
+
+// outcomeOf returns the outcome of a status code
+//	codes missing in statusCode are classified by their class
+func outcomeOf(code int) Outcome {
+	if status := GetStatusCodeString(code); status != "" && status != "redirect" {
+		return Outcome(status)
+	}
+	switch {
+	case code == 429:
+		return OutcomeRetry
+	case code >= 200 && code < 300:
+		return OutcomeSuccess
+	case code >= 500:
+		return OutcomeRetry
+	}
+	return OutcomeFail
+}
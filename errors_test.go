@@ -0,0 +1,134 @@
+package esme
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_RequestErrors(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/slow":
+			time.Sleep(200 * time.Millisecond)
+		case "/gzip":
+			w.Header().Set("Content-Encoding", "gzip")
+			_, _ = w.Write([]byte("not gzip"))
+		case "/limited":
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer ts.Close()
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	cases := []struct {
+		name    string
+		ctx     *Context
+		kind    ErrorKind
+		outcome Outcome
+	}{
+		{"url", DoRequest("ht", "GET"), ErrorRequest, OutcomeError},
+		{"timeout", HttpGet(ts.URL + "/slow").SetTimeOut(50), ErrorTimeout, OutcomeError},
+		{"dns", HttpGet("http://esme.invalid/"), ErrorDNS, OutcomeError},
+		{"tls", HttpGet(strings.Replace(ts.URL, "http://", "https://", 1)), ErrorTLS, OutcomeError},
+		{"proxy", HttpGet(ts.URL).SetProxy("http://127.0.0.1:1"), ErrorProxy, OutcomeError},
+		{"decode", HttpGet(ts.URL + "/gzip"), ErrorDecode, OutcomeError},
+		{"cancelled", HttpGet(ts.URL).WithContext(cancelled), ErrorCancelled, OutcomeCancelled},
+	}
+	for _, item := range cases {
+		var (
+			kind      ErrorKind
+			failed    bool
+			completed bool
+		)
+		err := item.ctx.
+			SetErrorFunc(func(ctx *Context, err *RequestError) { kind = err.Kind }).
+			SetFailedFunc(func(ctx *Context) { failed = true }).
+			SetCompleteFunc(func(ctx *Context) { completed = true }).
+			Do()
+		if err == nil || kind != item.kind || item.ctx.Outcome() != item.outcome {
+			t.Fatalf("%s: err %v kind %s outcome %s", item.name, err, kind, item.ctx.Outcome())
+		}
+		if !failed || !completed {
+			t.Fatalf("%s: failed %v completed %v", item.name, failed, completed)
+		}
+	}
+
+	// temporary errors go to the retry callback
+	var retried bool
+	ctx := HttpGet(ts.URL + "/slow").SetTimeOut(50).SetRetryFunc(func(ctx *Context) { retried = true })
+	if err := ctx.Do(); err == nil || !retried {
+		t.Fatalf("err %v retried %v", err, retried)
+	}
+
+	ctx = HttpGet(ts.URL + "/limited")
+	if err := ctx.Do(); err != nil || ctx.Outcome() != OutcomeRetry {
+		t.Fatalf("err %v outcome %s", err, ctx.Outcome())
+	}
+}
+
+func Test_MaxRetries(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	var retried, failed int
+	ctx := HttpGet(ts.URL).
+		SetRetryFunc(func(ctx *Context) { retried++ }).
+		SetFailedFunc(func(ctx *Context) { failed++ })
+	if err := ctx.Do(); err != nil || ctx.Outcome() != OutcomeFail {
+		t.Fatalf("err %v outcome %s", err, ctx.Outcome())
+	}
+	if hits != defaultMaxRetries+1 || retried != defaultMaxRetries || failed != 1 {
+		t.Fatalf("hits %d retried %d failed %d", hits, retried, failed)
+	}
+
+	atomic.StoreInt32(&hits, 0)
+	job := NewJob("retries", 1, NewMemQueue(), JobOptions{MaxRetries: 1, RetryFunc: func(ctx *Context) {}})
+	job.queue.Add(&Task{Url: ts.URL, Method: "GET"})
+	job.Do()
+	if stats := job.Stats(); hits != 2 || stats.Failed != 1 {
+		t.Fatalf("hits %d stats %s", hits, stats)
+	}
+}
+
+func Test_JobInvalidURL(t *testing.T) {
+	var kinds []ErrorKind
+	job := NewJob("invalid", 1, NewMemQueue(), JobOptions{
+		ErrorFunc: func(ctx *Context, err *RequestError) { kinds = append(kinds, err.Kind) },
+	})
+	job.queue.Add(&Task{Url: "ht", Method: "GET"})
+	job.queue.Add(&Task{Url: "http://a b", Method: "GET"})
+	job.Do()
+	if stats := job.Stats(); len(kinds) != 2 || kinds[0] != ErrorRequest || stats.Total != 2 || stats.Error != 2 {
+		t.Fatalf("kinds %v stats %s", kinds, stats)
+	}
+}
+
+func Test_RetryClearsResponse(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte("busy"))
+			return
+		}
+		// the retry fails without a response
+		panic(http.ErrAbortHandler)
+	}))
+	defer ts.Close()
+
+	ctx := HttpGet(ts.URL).SetRetryFunc(func(ctx *Context) {})
+	if err := ctx.Do(); err == nil || ctx.Response != nil || ctx.RespBody != nil {
+		t.Fatalf("err %v response %v body %s", err, ctx.Response, ctx.RespBody)
+	}
+}
@@ -7,11 +7,24 @@ sam
 package esme
 
 import (
+	"net/http"
+	"net/url"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/zituocn/esme/logx"
 )
 
+const (
+
+	// idleWaitTime wait time of an idle goroutine before checking the queue again
+	idleWaitTime = 100 * time.Millisecond
+
+	// robotsCacheTTL how long robots.txt is cached in a job
+	robotsCacheTTL = 24 * time.Hour
+)
+
 // Job job struct
 type Job struct {
 
@@ -26,6 +39,46 @@ type Job struct {
 
 	// jobOptions job options
 	jobOptions JobOptions
+
+	// running number of tasks being executed
+	running int32
+
+	// stats job statistics
+	stats *JobStats
+
+	// scheduler keep requests to the same host apart
+	scheduler *hostScheduler
+
+	// robots robots.txt cache, nil when JobOptions.RespectRobots is false
+	robots *RobotsCache
+
+	// bandwidth transfer rate limit of the job, nil when unlimited
+	bandwidth *Bandwidth
+
+	// proxyBandwidths transfer rate limits of the proxies, proxy -> *Bandwidth
+	proxyBandwidths sync.Map
+
+	// profileOnce choose the profile of RotatePerJob
+	profileOnce sync.Once
+
+	// profile browser profile of RotatePerJob
+	profile *BrowserProfile
+
+	// sessionProfiles browser profiles of RotatePerSession, session -> *BrowserProfile
+	sessionProfiles sync.Map
+
+	// userAgents sticky user-agents of JobOptions.UserAgent, key -> user-agent
+	userAgents sync.Map
+
+	// transports shared transports of the requests, transportKey -> *http.Transport
+	transports sync.Map
+}
+
+// transportKey settings of a shared transport
+type transportKey struct {
+	proxy    string
+	resolver *Resolver
+	tls      *TLSOptions
 }
 
 // JobOptions 任务参数
@@ -40,9 +93,16 @@ type JobOptions struct {
 	// RetryFunc retry callback
 	RetryFunc CallbackFunc
 
+	// MaxRetries max times a request is executed again after a retry status code,
+	//	3 by default
+	MaxRetries int
+
 	// FailedFunc callback after failure
 	FailedFunc CallbackFunc
 
+	// ErrorFunc callback of request errors
+	ErrorFunc ErrorFunc
+
 	// CompleteFunc Callback for request completion
 	CompleteFunc CallbackFunc
 
@@ -62,6 +122,77 @@ type JobOptions struct {
 
 	// 是否打印调试
 	IsDebug bool
+
+	// HostDelay min interval between requests to the same host
+	// millisecond
+	HostDelay int
+
+	// RespectRobots skip tasks disallowed by robots.txt
+	//	Crawl-delay of robots.txt is applied like HostDelay
+	RespectRobots bool
+
+	// RobotsUserAgent user-agent matched against robots.txt groups
+	//	default is the User-Agent sent by the request
+	RobotsUserAgent string
+
+	// Cache http response cache
+	Cache Cache
+
+	// CacheMode how cached responses are used
+	CacheMode CacheMode
+
+	// CacheIgnoreQuery query parameters left out of the cache key,
+	//	like the VaryingParams of a ParamSigner
+	CacheIgnoreQuery []string
+
+	// Cassette record or replay the requests of the job
+	Cassette *Cassette
+
+	// HAR record the requests of the job as HAR
+	HAR *HARRecorder
+
+	// Redirect how redirects are followed
+	Redirect *RedirectPolicy
+
+	// Middlewares middlewares of every request of the job
+	Middlewares []Middleware
+
+	// MaxBodySize max size of a response body in bytes, 0 means unlimited
+	MaxBodySize int64
+
+	// AllowedContentTypes content types of the responses read, empty means all
+	AllowedContentTypes []string
+
+	// Bandwidth max transfer rate of the job in bytes per second, 0 means unlimited
+	Bandwidth int64
+
+	// ProxyBandwidth max transfer rate of each proxy in bytes per second, 0 means unlimited
+	ProxyBandwidth int64
+
+	// Resolver dns resolver shared by the requests of the job
+	Resolver *Resolver
+
+	// TLS tls options of the requests of the job, certificates are verified by default
+	TLS *TLSOptions
+
+	// BrowserProfiles browser profiles sent by the requests,
+	//	the headers of the task are kept
+	BrowserProfiles *BrowserProfiles
+
+	// ProfileRotation how often the browser profile changes, RotatePerRequest by default
+	ProfileRotation ProfileRotation
+
+	// UserAgent user-agent of the tasks without a User-Agent header
+	UserAgent *UserAgentPolicy
+
+	// Auth authentication of the requests of the job
+	Auth Auth
+
+	// SessionAuth authentication of the tasks of a session (Task.Session), overrides Auth
+	SessionAuth map[string]Auth
+
+	// Signer sign the requests of the job before every attempt
+	Signer Signer
 }
 
 // NewJob returns a  *Job
@@ -69,12 +200,24 @@ func NewJob(name string, num int, queue TodoQueue, options JobOptions) *Job {
 	if num < 1 {
 		num = 1
 	}
-	return &Job{
+	job := &Job{
 		name:       name,
 		num:        num,
 		queue:      queue,
 		jobOptions: options,
+		stats:      newJobStats(),
+		scheduler:  newHostScheduler(),
+		bandwidth:  NewBandwidth(options.Bandwidth),
+	}
+	if options.RespectRobots {
+		job.robots = NewRobotsCache(robotsCacheTTL)
 	}
+	return job
+}
+
+// Stats returns the statistics of the job
+func (j *Job) Stats() JobStats {
+	return j.stats.snapshot()
 }
 
 // Do start the job
@@ -89,32 +232,204 @@ func (j *Job) Do() {
 			logx.Infof("start task %d", i+1)
 			defer wg.Done()
 			for {
-				if j.queue.IsEmpty() {
-					break
-				}
+				// a running task may still add new tasks to the queue,
+				// so only stop when the queue is empty and no task is running
+				atomic.AddInt32(&j.running, 1)
 				task := j.queue.Pop()
-				if task != nil {
-					ctx := DoRequest(task.Url, task.Method, task.Header, task.FormData, task.Payload, task)
-
-					ctx.SetStartFunc(j.jobOptions.StartFunc).
-						SetSucceedFunc(j.jobOptions.SucceedFunc).
-						SetRetryFunc(j.jobOptions.RetryFunc).
-						SetFailedFunc(j.jobOptions.FailedFunc).
-						SetCompleteFunc(j.jobOptions.CompleteFunc).
-						SetIsDebug(j.jobOptions.IsDebug).
-						SetTimeOut(j.jobOptions.TimeOut).
-						SetSleepTime(j.jobOptions.SheepTime).
-						SetProxy(j.jobOptions.ProxyIP).
-						SetProxyLib(j.jobOptions.ProxyLib)
-
-					// execute request
-					ctx.Do()
+				if task == nil {
+					// Pop also returns nil on an error of the queue, like a redis error
+					if atomic.AddInt32(&j.running, -1) == 0 && j.queue.IsEmpty() {
+						break
+					}
+					time.Sleep(idleWaitTime)
+					continue
 				}
+				j.execute(task)
+				atomic.AddInt32(&j.running, -1)
 			}
 
 		}(n)
 	}
 	wg.Wait()
 
-	logx.Info("job done")
+	if j.jobOptions.Cassette != nil {
+		if err := j.jobOptions.Cassette.Close(); err != nil {
+			logx.Errorf("[%s] save cassette error: %s", j.name, err.Error())
+		}
+	}
+
+	logx.Infof("[%s] job done -> %s", j.name, j.Stats())
+}
+
+// execute run a task with the job options
+func (j *Job) execute(task *Task) {
+	vs := []interface{}{task.Header, task.FormData, task.Multipart, task.Payload, task}
+	if len(task.JSON) > 0 {
+		vs = append(vs, JSON(task.JSON))
+	}
+	ctx := DoRequest(task.Url, task.Method, vs...)
+
+	ctx.SetStartFunc(j.jobOptions.StartFunc).
+		SetSucceedFunc(j.jobOptions.SucceedFunc).
+		SetRetryFunc(j.jobOptions.RetryFunc).
+		SetFailedFunc(j.jobOptions.FailedFunc).
+		SetErrorFunc(j.jobOptions.ErrorFunc).
+		SetCompleteFunc(j.jobOptions.CompleteFunc).
+		SetMaxRetries(j.jobOptions.MaxRetries).
+		SetIsDebug(j.jobOptions.IsDebug).
+		SetTimeOut(j.jobOptions.TimeOut).
+		SetSleepTime(j.jobOptions.SheepTime).
+		SetProxy(j.jobOptions.ProxyIP).
+		SetProxyLib(j.jobOptions.ProxyLib).
+		SetProxy(task.Proxy).
+		SetResolver(j.jobOptions.Resolver).
+		SetTLS(j.jobOptions.TLS).
+		SetAuth(j.auth(task)).
+		SetSigner(j.jobOptions.Signer).
+		SetCache(j.jobOptions.Cache, j.jobOptions.CacheMode).
+		SetCacheIgnoreQuery(j.jobOptions.CacheIgnoreQuery...).
+		SetCassette(j.jobOptions.Cassette).
+		SetHAR(j.jobOptions.HAR).
+		SetRedirectPolicy(j.jobOptions.Redirect).
+		Use(j.jobOptions.Middlewares...).
+		SetMaxBodySize(j.jobOptions.MaxBodySize).
+		SetAllowedContentTypes(j.jobOptions.AllowedContentTypes...)
+
+	// the proxy is known once the options are set
+	profile := j.browserProfile(task)
+	ctx.SetBandwidth(j.bandwidth, j.proxyBandwidth(ctx.proxy)).
+		SetBrowserProfile(profile)
+	if ua := ctx.UserAgent(); profile == nil && (ua == "" || ua == defaultUserAgent) {
+		ctx.SetUserAgent(j.userAgent(task, ctx.proxy))
+	}
+
+	// requests with the same proxy, resolver and tls options reuse connections
+	if _, ok := ctx.client.Transport.(*http.Transport); ok {
+		ctx.client.Transport = j.transport(ctx)
+	}
+
+	// robots.txt and the host delay, an invalid url skips them and Do returns its error
+	if ctx.buildErr == nil {
+		u := ctx.Request.URL
+		delay := time.Duration(j.jobOptions.HostDelay) * time.Millisecond
+		if j.robots != nil {
+			// robots.txt is fetched through the transport of the task, with the user-agent it sends
+			agent := j.robotsUserAgent(ctx)
+			client := &http.Client{Transport: ctx.client.Transport, Timeout: ctx.client.Timeout}
+			robots := j.robots.GetWith(u.String(), client, ctx.UserAgent())
+			if !robots.Allowed(agent, u.RequestURI()) {
+				atomic.AddInt64(&j.stats.Disallowed, 1)
+				logx.Warnf("disallowed by robots.txt: %s", u.String())
+				return
+			}
+			if d := robots.CrawlDelay(agent); d > delay {
+				delay = d
+			}
+		}
+		j.scheduler.wait(u.Host, delay)
+	}
+
+	// execute request
+	ctx.Do()
+	j.stats.add(ctx)
+
+	// next page of a paginated task
+	if task.Paginator != nil && ctx.outcome == OutcomeSuccess {
+		if next := task.Paginator.Next(ctx); next != nil {
+			j.queue.Add(next)
+		}
+	}
+}
+
+// proxyBandwidth returns the transfer rate limit of a proxy, nil when unlimited
+func (j *Job) proxyBandwidth(proxy string) *Bandwidth {
+	if proxy == "" || j.jobOptions.ProxyBandwidth <= 0 {
+		return nil
+	}
+	item, _ := j.proxyBandwidths.LoadOrStore(proxy, NewBandwidth(j.jobOptions.ProxyBandwidth))
+	return item.(*Bandwidth)
+}
+
+// browserProfile returns the browser profile of a task, nil when the job has none
+func (j *Job) browserProfile(task *Task) *BrowserProfile {
+	profiles := j.jobOptions.BrowserProfiles
+	if profiles == nil {
+		return nil
+	}
+	switch j.jobOptions.ProfileRotation {
+	case RotatePerJob:
+		j.profileOnce.Do(func() {
+			j.profile = profiles.Random()
+		})
+		return j.profile
+	case RotatePerSession:
+		if task.Session != "" {
+			if item, ok := j.sessionProfiles.Load(task.Session); ok {
+				return item.(*BrowserProfile)
+			}
+			profile := profiles.Random()
+			if profile == nil {
+				return nil
+			}
+			item, _ := j.sessionProfiles.LoadOrStore(task.Session, profile)
+			return item.(*BrowserProfile)
+		}
+	}
+	return profiles.Random()
+}
+
+// userAgent returns the user-agent of JobOptions.UserAgent for a task, "" when the job has none
+func (j *Job) userAgent(task *Task, proxy string) string {
+	policy := j.jobOptions.UserAgent
+	if policy == nil {
+		return ""
+	}
+	if policy.Mode == UAFixed && len(policy.UserAgents) > 0 {
+		return policy.UserAgents[0]
+	}
+	key := policy.sticky(task, proxy)
+	if key == "" {
+		return policy.Random()
+	}
+	if item, ok := j.userAgents.Load(key); ok {
+		return item.(string)
+	}
+	item, _ := j.userAgents.LoadOrStore(key, policy.Random())
+	return item.(string)
+}
+
+// auth returns the auth of a task, nil when the job has none
+func (j *Job) auth(task *Task) Auth {
+	if auth, ok := j.jobOptions.SessionAuth[task.Session]; ok && task.Session != "" {
+		return auth
+	}
+	return j.jobOptions.Auth
+}
+
+// transport returns the shared transport of the proxy, the resolver and the tls options of ctx
+func (j *Job) transport(ctx *Context) *http.Transport {
+	key := transportKey{proxy: ctx.proxy, resolver: ctx.resolver, tls: ctx.tls}
+	if item, ok := j.transports.Load(key); ok {
+		return item.(*http.Transport)
+	}
+	transport := getDefaultTransport()
+	if ctx.proxy != "" {
+		proxy, _ := url.Parse(ctx.proxy)
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	ctx.configureTransport(transport)
+	item, _ := j.transports.LoadOrStore(key, transport)
+	return item.(*http.Transport)
+}
+
+// robotsUserAgent returns the user-agent matched against robots.txt
+//	the User-Agent sent by ctx, chosen by the task, the browser profile or the policy
+func (j *Job) robotsUserAgent(ctx *Context) string {
+	if j.jobOptions.RobotsUserAgent != "" {
+		return j.jobOptions.RobotsUserAgent
+	}
+	if ua := ctx.UserAgent(); ua != "" {
+		return ua
+	}
+	return defaultUserAgent
 }
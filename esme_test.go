@@ -0,0 +1,69 @@
+package esme
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func Test_JobTransport(t *testing.T) {
+	var conns int32
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	ts.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	ts.Start()
+	defer ts.Close()
+
+	job := NewJob("transport", 1, NewMemQueue(), JobOptions{})
+	for i := 0; i < 5; i++ {
+		job.queue.Add(&Task{Url: ts.URL, Method: "GET"})
+	}
+	job.Do()
+	if stats := job.Stats(); stats.Succeed != 5 || conns != 1 {
+		t.Fatalf("connections %d stats %s", conns, stats)
+	}
+
+	a := job.transport(HttpGet(ts.URL).SetProxy("http://127.0.0.1:8001"))
+	b := job.transport(HttpGet(ts.URL).SetProxy("http://127.0.0.1:8001"))
+	c := job.transport(HttpGet(ts.URL).SetProxy("http://127.0.0.1:8002"))
+	d := job.transport(HttpGet(ts.URL).SetResolver(&Resolver{}))
+	if a != b || a == c || a == d || a.Proxy == nil || d.DialContext == nil {
+		t.Fatal("transports are not shared by proxy and resolver")
+	}
+}
+
+// flakyQueue a queue whose first Pop fails like a redis error
+type flakyQueue struct {
+	*MemQueue
+	failed int32
+}
+
+func (q *flakyQueue) Pop() *Task {
+	if atomic.CompareAndSwapInt32(&q.failed, 0, 1) {
+		return nil
+	}
+	return q.MemQueue.Pop()
+}
+
+func Test_JobQueueError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	queue := &flakyQueue{MemQueue: NewMemQueue().(*MemQueue)}
+	queue.Add(&Task{Url: ts.URL, Method: "GET"})
+	queue.Add(&Task{Url: ts.URL, Method: "GET"})
+	job := NewJob("flaky", 1, queue, JobOptions{})
+	job.Do()
+	if stats := job.Stats(); stats.Succeed != 2 {
+		t.Fatalf("stats %s", stats)
+	}
+}
@@ -0,0 +1,428 @@
+/*
+extract.go
+declarative extraction of html / json responses into structs
+*/
+
+package esme
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/tidwall/gjson"
+	"golang.org/x/net/html"
+)
+
+var (
+
+	// regexpCache compiled regular expressions of `re` tags
+	regexpCache sync.Map
+
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+
+	// defaultTimeLayouts layouts tried when a time field has no `layout` tag
+	defaultTimeLayouts = []string{
+		time.RFC3339,
+		"2006-01-02 15:04:05",
+		"2006-01-02 15:04",
+		"2006-01-02",
+		"2006/01/02 15:04:05",
+		"2006/01/02",
+		time.RFC1123Z,
+		time.RFC1123,
+	}
+)
+
+// FieldError a struct field that failed to extract
+type FieldError struct {
+
+	// Field path of the field, like: Items[2].Price
+	Field string
+
+	// Err reason
+	Err error
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Err.Error())
+}
+
+// ExtractError returned by ctx.Extract when some fields failed
+//	the other fields are still filled
+type ExtractError struct {
+	Errors []*FieldError
+}
+
+func (e *ExtractError) Error() string {
+	items := make([]string, 0, len(e.Errors))
+	for _, item := range e.Errors {
+		items = append(items, item.Error())
+	}
+	return "extract failed: " + strings.Join(items, "; ")
+}
+
+// Fields returns the paths of the failed fields
+func (e *ExtractError) Fields() []string {
+	fields := make([]string, 0, len(e.Errors))
+	for _, item := range e.Errors {
+		fields = append(fields, item.Field)
+	}
+	return fields
+}
+
+// Extract fill the struct pointed by v from the response body
+//	fields are described by tags:
+//		css:"h1.title"              css selector, text of the element
+//		css:"a.next" attr:"href"    attribute of the element, attr:"html" for inner html
+//		xpath:"//a/@href"           xpath expression
+//		json:"data.items.#.id"      gjson path
+//		re:"price: (\\d+)"          regular expression, the first group when present
+//		layout:"2006-01-02"         layout of a time.Time field
+//	nested structs and slices are supported, a tagged struct field or slice of
+//	structs selects the scope that its own fields are extracted from.
+//	a non-slice field without any match is reported in *ExtractError
+func (c *Context) Extract(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return errors.New("extract: v must be a non-nil pointer to struct")
+	}
+	e := &extractor{}
+	e.extractStruct(&extractScope{ctx: c}, rv.Elem(), "")
+	if len(e.errs) > 0 {
+		return &ExtractError{Errors: e.errs}
+	}
+	return nil
+}
+
+/*
+private
+*/
+
+// extractScope the part of the response that tags are evaluated against
+//	nil members mean the whole response
+type extractScope struct {
+	ctx  *Context
+	sel  *goquery.Selection
+	node *html.Node
+	json *gjson.Result
+	text *string
+}
+
+func (s *extractScope) selection() *goquery.Selection {
+	if s.sel == nil {
+		return s.ctx.HTML().Selection
+	}
+	return s.sel
+}
+
+func (s *extractScope) root() *html.Node {
+	if s.node == nil {
+		return s.ctx.xpathRoot()
+	}
+	return s.node
+}
+
+func (s *extractScope) jsonResult() gjson.Result {
+	if s.json == nil {
+		return gjson.ParseBytes(s.ctx.ToUTF8())
+	}
+	return *s.json
+}
+
+func (s *extractScope) content() string {
+	if s.text == nil {
+		return string(s.ctx.ToUTF8())
+	}
+	return *s.text
+}
+
+// extractItem a single match of a tag
+type extractItem struct {
+	value string
+	scope *extractScope
+}
+
+type extractor struct {
+	errs []*FieldError
+}
+
+func (e *extractor) fail(field string, err error) {
+	e.errs = append(e.errs, &FieldError{Field: field, Err: err})
+}
+
+func (e *extractor) extractStruct(scope *extractScope, rv reflect.Value, prefix string) {
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		sf := rt.Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+		name := sf.Name
+		if prefix != "" {
+			name = prefix + "." + sf.Name
+		}
+		e.extractField(scope, sf, rv.Field(i), name)
+	}
+}
+
+func (e *extractor) extractField(scope *extractScope, sf reflect.StructField, fv reflect.Value, name string) {
+	ft := sf.Type
+	multi := ft.Kind() == reflect.Slice && ft.Elem().Kind() != reflect.Uint8
+	elemType := ft
+	if multi {
+		elemType = ft.Elem()
+	}
+
+	if !hasExtractTag(sf.Tag) {
+		// an untagged nested struct is extracted from the same scope
+		if isNestedStruct(elemType) && !multi {
+			e.extractStruct(scope, indirectValue(fv), name)
+		}
+		return
+	}
+
+	items, err := matchTag(scope, sf.Tag, multi)
+	if err != nil {
+		e.fail(name, err)
+		return
+	}
+	if !multi {
+		if len(items) == 0 {
+			e.fail(name, errors.New("no match"))
+			return
+		}
+		e.setItem(items[0], sf.Tag, fv, name)
+		return
+	}
+
+	slice := reflect.MakeSlice(ft, len(items), len(items))
+	for i, item := range items {
+		e.setItem(item, sf.Tag, slice.Index(i), fmt.Sprintf("%s[%d]", name, i))
+	}
+	fv.Set(slice)
+}
+
+func (e *extractor) setItem(item *extractItem, tag reflect.StructTag, fv reflect.Value, name string) {
+	if isNestedStruct(fv.Type()) {
+		e.extractStruct(item.scope, indirectValue(fv), name)
+		return
+	}
+	if err := setFieldValue(indirectValue(fv), item.value, tag.Get("layout")); err != nil {
+		e.fail(name, err)
+	}
+}
+
+func hasExtractTag(tag reflect.StructTag) bool {
+	for _, key := range []string{"css", "xpath", "json", "re"} {
+		if v, ok := tag.Lookup(key); ok && v != "" && v != "-" {
+			return true
+		}
+	}
+	return false
+}
+
+// matchTag evaluate the tag of a field in scope
+func matchTag(scope *extractScope, tag reflect.StructTag, multi bool) ([]*extractItem, error) {
+	items := make([]*extractItem, 0)
+
+	if selector := tag.Get("css"); selector != "" && selector != "-" {
+		attr := tag.Get("attr")
+		scope.selection().Find(selector).Each(func(i int, s *goquery.Selection) {
+			value := strings.TrimSpace(s.Text())
+			switch attr {
+			case "":
+			case "html":
+				value, _ = s.Html()
+			default:
+				value = s.AttrOr(attr, "")
+			}
+			text := s.Text()
+			items = append(items, &extractItem{
+				value: value,
+				scope: &extractScope{ctx: scope.ctx, sel: s, node: s.Nodes[0], text: &text},
+			})
+		})
+		return items, nil
+	}
+
+	if expr := tag.Get("xpath"); expr != "" && expr != "-" {
+		nodes, err := XPathNodes(scope.root(), expr)
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range nodes {
+			text := n.Text()
+			items = append(items, &extractItem{
+				value: strings.TrimSpace(text),
+				scope: &extractScope{ctx: scope.ctx, sel: goquery.NewDocumentFromNode(n.Node).Selection, node: n.Node, text: &text},
+			})
+		}
+		return items, nil
+	}
+
+	if path := tag.Get("json"); path != "" && path != "-" {
+		result := scope.jsonResult().Get(path)
+		if !result.Exists() {
+			return items, nil
+		}
+		results := []gjson.Result{result}
+		if multi && result.IsArray() {
+			results = result.Array()
+		}
+		for _, r := range results {
+			r := r
+			raw := r.Raw
+			items = append(items, &extractItem{
+				value: r.String(),
+				scope: &extractScope{ctx: scope.ctx, json: &r, text: &raw},
+			})
+		}
+		return items, nil
+	}
+
+	if expr := tag.Get("re"); expr != "" && expr != "-" {
+		re, err := compileRegexp(expr)
+		if err != nil {
+			return nil, err
+		}
+		n := 1
+		if multi {
+			n = -1
+		}
+		for _, match := range re.FindAllStringSubmatch(scope.content(), n) {
+			value := match[0]
+			if len(match) > 1 {
+				value = match[1]
+			}
+			items = append(items, &extractItem{
+				value: value,
+				scope: &extractScope{ctx: scope.ctx, text: &value},
+			})
+		}
+	}
+	return items, nil
+}
+
+func compileRegexp(expr string) (*regexp.Regexp, error) {
+	if v, ok := regexpCache.Load(expr); ok {
+		return v.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	regexpCache.Store(expr, re)
+	return re, nil
+}
+
+func isNestedStruct(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct && t != timeType
+}
+
+// indirectValue allocate nil pointers and returns the value they point to
+func indirectValue(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+// setFieldValue convert s to the type of v
+func setFieldValue(v reflect.Value, s string, layout string) error {
+	if v.Type() == timeType {
+		t, err := parseTime(s, layout)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(t))
+		return nil
+	}
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(strings.TrimSpace(s))
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(strings.TrimSpace(s))
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(cleanNumber(s), 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		i, err := strconv.ParseUint(cleanNumber(s), 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(i)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(cleanNumber(s), v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			v.SetBytes([]byte(s))
+			return nil
+		}
+		return fmt.Errorf("unsupported type %s", v.Type())
+	case reflect.Interface:
+		v.Set(reflect.ValueOf(s))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// cleanNumber remove spaces and thousands separators
+//	like: " 1,299 " -> 1299
+func cleanNumber(s string) string {
+	return strings.ReplaceAll(strings.TrimSpace(s), ",", "")
+}
+
+func parseTime(s, layout string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if layout != "" {
+		return time.ParseInLocation(layout, s, time.Local)
+	}
+	for _, item := range defaultTimeLayouts {
+		if t, err := time.ParseInLocation(item, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	if ts, err := strconv.ParseInt(s, 10, 64); err == nil {
+		// unix timestamp in seconds or milliseconds
+		if ts > 1e12 {
+			return time.Unix(0, ts*int64(time.Millisecond)), nil
+		}
+		return time.Unix(ts, 0), nil
+	}
+	return time.Time{}, fmt.Errorf("can not parse time %q", s)
+}
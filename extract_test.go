@@ -0,0 +1,97 @@
+package esme
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type testArticle struct {
+	Title  string    `css:"h1.title"`
+	Link   string    `xpath:"//a[@class='more']/@href"`
+	Price  int       `re:"price: (\\d+)"`
+	Date   time.Time `css:"span.date" layout:"2006年01月02日"`
+	Tags   []string  `css:"ul.tags li"`
+	Author *struct {
+		Name string `css:"b"`
+		Home string `css:"a" attr:"href"`
+	} `css:"div.author"`
+	Comments []struct {
+		User  string  `xpath:".//span[@class='user']"`
+		Score float64 `css:"span.score"`
+	} `css:"div.comment"`
+	Missing string `css:"div.none"`
+}
+
+type testItems struct {
+	Total int   `json:"data.total"`
+	IDs   []int `json:"data.items.#.id"`
+	Items []struct {
+		ID   int    `json:"id"`
+		Name string `json:"name"`
+	} `json:"data.items"`
+}
+
+func Test_ContextExtract(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api" {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"data":{"total":2,"items":[{"id":1,"name":"北京"},{"id":2,"name":"成都"}]}}`))
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write([]byte(`<html><body>
+<h1 class="title"> 标题 </h1><a class="more" href="/more">more</a><p>price: 1299</p>
+<span class="date">2022年05月01日</span>
+<ul class="tags"><li>go</li><li>spider</li></ul>
+<div class="author"><b>sam</b><a href="https://github.com/zituocn">home</a></div>
+<div class="comment"><span class="user">u1</span><span class="score">4.5</span></div>
+<div class="comment"><span class="user">u2</span><span class="score">bad</span></div>
+</body></html>`))
+	}))
+	defer ts.Close()
+
+	ctx := HttpGet(ts.URL)
+	ctx.Do()
+
+	article := new(testArticle)
+	err := ctx.Extract(article)
+	var extractErr *ExtractError
+	if !errors.As(err, &extractErr) {
+		t.Fatalf("expected ExtractError, got %v", err)
+	}
+	fields := extractErr.Fields()
+	if len(fields) != 2 || fields[0] != "Comments[1].Score" || fields[1] != "Missing" {
+		t.Fatalf("failed fields: got %v", fields)
+	}
+	if article.Title != "标题" || article.Link != "/more" || article.Price != 1299 {
+		t.Fatalf("article: got %+v", article)
+	}
+	if article.Date.Year() != 2022 || article.Date.Month() != time.May {
+		t.Fatalf("date: got %v", article.Date)
+	}
+	if len(article.Tags) != 2 || article.Tags[1] != "spider" {
+		t.Fatalf("tags: got %v", article.Tags)
+	}
+	if article.Author.Name != "sam" || article.Author.Home != "https://github.com/zituocn" {
+		t.Fatalf("author: got %+v", article.Author)
+	}
+	if len(article.Comments) != 2 || article.Comments[0].User != "u1" || article.Comments[0].Score != 4.5 {
+		t.Fatalf("comments: got %+v", article.Comments)
+	}
+
+	ctx = HttpGet(ts.URL + "/api")
+	ctx.Do()
+	items := new(testItems)
+	if err = ctx.Extract(items); err != nil {
+		t.Fatal(err)
+	}
+	if items.Total != 2 || len(items.IDs) != 2 || items.IDs[1] != 2 {
+		t.Fatalf("items: got %+v", items)
+	}
+	if len(items.Items) != 2 || items.Items[1].Name != "成都" {
+		t.Fatalf("items: got %+v", items.Items)
+	}
+}
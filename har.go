@@ -0,0 +1,533 @@
+/*
+har.go
+HAR 1.2 export of the requests made by esme, and import of browser HAR files as tasks
+*/
+
+package esme
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+
+	// defaultHARBodySize default max size of the bodies saved in a HAR
+	defaultHARBodySize = 1 << 20
+)
+
+// HAR http archive
+type HAR struct {
+	Log *HARLog `json:"log"`
+}
+
+// HARLog root of a HAR
+type HARLog struct {
+	Version string      `json:"version"`
+	Creator *HARCreator `json:"creator"`
+	Entries []*HAREntry `json:"entries"`
+}
+
+// HARCreator application that created the HAR
+type HARCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// HAREntry a request and its response
+type HAREntry struct {
+	StartedDateTime time.Time    `json:"startedDateTime"`
+	Time            float64      `json:"time"`
+	Request         *HARRequest  `json:"request"`
+	Response        *HARResponse `json:"response"`
+	Cache           struct{}     `json:"cache"`
+	Timings         *HARTimings  `json:"timings"`
+	ServerIPAddress string       `json:"serverIPAddress,omitempty"`
+	Connection      string       `json:"connection,omitempty"`
+
+	// Proxy proxy used by the request, the password is masked
+	Proxy string `json:"_proxy,omitempty"`
+
+	// Error error of the request
+	Error string `json:"_error,omitempty"`
+}
+
+// HARRequest request of a HAR entry
+type HARRequest struct {
+	Method      string          `json:"method"`
+	URL         string          `json:"url"`
+	HTTPVersion string          `json:"httpVersion"`
+	Cookies     []*HARCookie    `json:"cookies"`
+	Headers     []*HARNameValue `json:"headers"`
+	QueryString []*HARNameValue `json:"queryString"`
+	PostData    *HARPostData    `json:"postData,omitempty"`
+	HeadersSize int64           `json:"headersSize"`
+	BodySize    int64           `json:"bodySize"`
+}
+
+// HARResponse response of a HAR entry
+type HARResponse struct {
+	Status      int             `json:"status"`
+	StatusText  string          `json:"statusText"`
+	HTTPVersion string          `json:"httpVersion"`
+	Cookies     []*HARCookie    `json:"cookies"`
+	Headers     []*HARNameValue `json:"headers"`
+	Content     *HARContent     `json:"content"`
+	RedirectURL string          `json:"redirectURL"`
+	HeadersSize int64           `json:"headersSize"`
+	BodySize    int64           `json:"bodySize"`
+}
+
+// HARNameValue a header, query parameter or form field
+type HARNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// HARCookie a cookie
+type HARCookie struct {
+	Name     string     `json:"name"`
+	Value    string     `json:"value"`
+	Path     string     `json:"path,omitempty"`
+	Domain   string     `json:"domain,omitempty"`
+	Expires  *time.Time `json:"expires,omitempty"`
+	HTTPOnly bool       `json:"httpOnly,omitempty"`
+	Secure   bool       `json:"secure,omitempty"`
+}
+
+// HARPostData request body
+type HARPostData struct {
+	MimeType string          `json:"mimeType"`
+	Params   []*HARNameValue `json:"params,omitempty"`
+	Text     string          `json:"text"`
+}
+
+// HARContent response body
+type HARContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// HARTimings timings of a HAR entry in milliseconds, -1 when not available
+type HARTimings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+	SSL     float64 `json:"ssl"`
+}
+
+// HARRecorder record requests and responses as a HAR
+//	safe to use from multiple goroutines
+type HARRecorder struct {
+	mux *sync.Mutex
+
+	entries []*HAREntry
+
+	// MaxBodySize max size of the request and response bodies saved,
+	//	longer bodies are truncated, 0 means do not save bodies
+	MaxBodySize int
+}
+
+// NewHARRecorder returns a *HARRecorder saving bodies up to 1MB
+func NewHARRecorder() *HARRecorder {
+	return &HARRecorder{
+		mux:         &sync.Mutex{},
+		entries:     make([]*HAREntry, 0),
+		MaxBodySize: defaultHARBodySize,
+	}
+}
+
+// HAR returns the recorded entries as a HAR
+func (r *HARRecorder) HAR() *HAR {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	entries := make([]*HAREntry, len(r.entries))
+	copy(entries, r.entries)
+	return &HAR{
+		Log: &HARLog{
+			Version: "1.2",
+			Creator: &HARCreator{Name: "esme", Version: "1.0"},
+			Entries: entries,
+		},
+	}
+}
+
+// WriteTo write the HAR as json to w
+func (r *HARRecorder) WriteTo(w io.Writer) (int64, error) {
+	b, err := json.MarshalIndent(r.HAR(), "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(b)
+	return int64(n), err
+}
+
+// Save write the HAR to filename
+func (r *HARRecorder) Save(filename string) error {
+	b, err := json.MarshalIndent(r.HAR(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, b, 0644)
+}
+
+// SetHAR record the requests of the context with a HARRecorder
+func (c *Context) SetHAR(recorder *HARRecorder) *Context {
+	if recorder == nil {
+		return c
+	}
+	c.har = recorder
+	return c
+}
+
+// LoadHAR returns the tasks of the requests in a HAR file
+//	like a HAR saved from the browser devtools
+func LoadHAR(filename string) ([]*Task, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return ParseHAR(b)
+}
+
+// ParseHAR returns the tasks of the requests in a HAR
+//	http/2 pseudo headers and headers managed by the transport are dropped
+func ParseHAR(b []byte) ([]*Task, error) {
+	har := new(HAR)
+	if err := json.Unmarshal(b, har); err != nil {
+		return nil, err
+	}
+	tasks := make([]*Task, 0)
+	if har.Log == nil {
+		return tasks, nil
+	}
+	for _, entry := range har.Log.Entries {
+		if entry.Request == nil {
+			continue
+		}
+		tasks = append(tasks, entry.Request.task())
+	}
+	return tasks, nil
+}
+
+/*
+private
+*/
+
+func (r *HARRecorder) add(entry *HAREntry) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.entries = append(r.entries, entry)
+}
+
+// task convert the request to a Task
+func (r *HARRequest) task() *Task {
+	header := http.Header{}
+	for _, item := range r.Headers {
+		if strings.HasPrefix(item.Name, ":") {
+			continue
+		}
+		switch http.CanonicalHeaderKey(item.Name) {
+		case "Content-Length", "Host", "Connection", "Accept-Encoding":
+			continue
+		}
+		header.Add(item.Name, item.Value)
+	}
+	if header.Get("Cookie") == "" && len(r.Cookies) > 0 {
+		cookies := make([]string, 0, len(r.Cookies))
+		for _, item := range r.Cookies {
+			cookies = append(cookies, item.Name+"="+item.Value)
+		}
+		header.Set("Cookie", strings.Join(cookies, "; "))
+	}
+
+	task := &Task{
+		Url:    r.URL,
+		Method: r.Method,
+		Header: &header,
+	}
+	if r.PostData != nil {
+		if strings.HasPrefix(r.PostData.MimeType, "application/x-www-form-urlencoded") && r.PostData.Text == "" && len(r.PostData.Params) > 0 {
+			task.FormData = FormData{}
+			for _, item := range r.PostData.Params {
+				task.FormData.Set(item.Name, item.Value)
+			}
+		} else if r.PostData.Text != "" {
+			task.Payload = []byte(r.PostData.Text)
+		}
+		if header.Get("Content-Type") == "" && r.PostData.MimeType != "" {
+			header.Set("Content-Type", r.PostData.MimeType)
+		}
+	}
+	return task
+}
+
+// harTransport record requests to a HARRecorder
+type harTransport struct {
+	base     http.RoundTripper
+	recorder *HARRecorder
+}
+
+// harTrace timestamps of a request collected with httptrace
+type harTrace struct {
+	start, dnsStart, dnsDone, connectStart, connectDone time.Time
+	tlsStart, tlsDone, wroteRequest, firstByte          time.Time
+	remoteAddr                                          string
+	reused                                              bool
+}
+
+func (t *harTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	tr := &harTrace{start: time.Now()}
+	trace := &httptrace.ClientTrace{
+		DNSStart:          func(httptrace.DNSStartInfo) { tr.dnsStart = time.Now() },
+		DNSDone:           func(httptrace.DNSDoneInfo) { tr.dnsDone = time.Now() },
+		ConnectStart:      func(string, string) { tr.connectStart = time.Now() },
+		ConnectDone:       func(string, string, error) { tr.connectDone = time.Now() },
+		TLSHandshakeStart: func() { tr.tlsStart = time.Now() },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { tr.tlsDone = time.Now() },
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Conn != nil {
+				tr.remoteAddr = info.Conn.RemoteAddr().String()
+			}
+			tr.reused = info.Reused
+		},
+		WroteRequest:         func(httptrace.WroteRequestInfo) { tr.wroteRequest = time.Now() },
+		GotFirstResponseByte: func() { tr.firstByte = time.Now() },
+	}
+	traced := req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+	entry := &HAREntry{
+		StartedDateTime: tr.start,
+		Request:         t.request(req, body),
+	}
+	if c := contextFromRequest(req); c != nil && c.proxy != "" {
+		entry.Proxy = maskProxy(c.proxy)
+	}
+
+	resp, err := t.base.RoundTrip(traced)
+	if err != nil {
+		entry.Error = err.Error()
+		entry.Response = &HARResponse{Cookies: []*HARCookie{}, Headers: []*HARNameValue{}, Content: &HARContent{}}
+		entry.Timings = tr.timings(time.Now())
+		entry.Time = msSince(tr.start, time.Now())
+		t.recorder.add(entry)
+		return nil, err
+	}
+
+	entry.Response = t.response(resp)
+	entry.ServerIPAddress, entry.Connection = splitHostPort(tr.remoteAddr)
+	resp.Body = &harBody{
+		ReadCloser: resp.Body,
+		limit:      t.recorder.MaxBodySize,
+		done: func(b []byte, size int64) {
+			end := time.Now()
+			entry.Response.Content.Size = size
+			entry.Response.BodySize = size
+			if len(b) > 0 {
+				if utf8.Valid(b) {
+					entry.Response.Content.Text = string(b)
+				} else {
+					entry.Response.Content.Text = base64.StdEncoding.EncodeToString(b)
+					entry.Response.Content.Encoding = "base64"
+				}
+			}
+			entry.Timings = tr.timings(end)
+			entry.Time = msSince(tr.start, end)
+			t.recorder.add(entry)
+		},
+	}
+	return resp, nil
+}
+
+func (t *harTransport) request(req *http.Request, body []byte) *HARRequest {
+	r := &HARRequest{
+		Method:      req.Method,
+		URL:         req.URL.String(),
+		HTTPVersion: req.Proto,
+		Cookies:     make([]*HARCookie, 0),
+		Headers:     harHeaders(req.Header),
+		QueryString: make([]*HARNameValue, 0),
+		HeadersSize: -1,
+		BodySize:    int64(len(body)),
+	}
+	if r.HTTPVersion == "" {
+		r.HTTPVersion = "HTTP/1.1"
+	}
+	for _, item := range req.Cookies() {
+		r.Cookies = append(r.Cookies, &HARCookie{Name: item.Name, Value: item.Value})
+	}
+	for key, values := range req.URL.Query() {
+		for _, value := range values {
+			r.QueryString = append(r.QueryString, &HARNameValue{Name: key, Value: value})
+		}
+	}
+	if len(body) > 0 {
+		r.PostData = &HARPostData{MimeType: req.Header.Get("Content-Type")}
+		if strings.HasPrefix(r.PostData.MimeType, "application/x-www-form-urlencoded") {
+			if values, err := url.ParseQuery(string(body)); err == nil {
+				for key, items := range values {
+					for _, value := range items {
+						r.PostData.Params = append(r.PostData.Params, &HARNameValue{Name: key, Value: value})
+					}
+				}
+			}
+		}
+		text := body
+		if t.recorder.MaxBodySize >= 0 && len(text) > t.recorder.MaxBodySize {
+			text = text[:t.recorder.MaxBodySize]
+		}
+		if utf8.Valid(text) {
+			r.PostData.Text = string(text)
+		} else {
+			r.PostData.Text = base64.StdEncoding.EncodeToString(text)
+		}
+	}
+	return r
+}
+
+func (t *harTransport) response(resp *http.Response) *HARResponse {
+	r := &HARResponse{
+		Status:      resp.StatusCode,
+		StatusText:  http.StatusText(resp.StatusCode),
+		HTTPVersion: resp.Proto,
+		Cookies:     make([]*HARCookie, 0),
+		Headers:     harHeaders(resp.Header),
+		Content:     &HARContent{MimeType: resp.Header.Get("Content-Type")},
+		RedirectURL: resp.Header.Get("Location"),
+		HeadersSize: -1,
+	}
+	for _, item := range resp.Cookies() {
+		cookie := &HARCookie{
+			Name:     item.Name,
+			Value:    item.Value,
+			Path:     item.Path,
+			Domain:   item.Domain,
+			HTTPOnly: item.HttpOnly,
+			Secure:   item.Secure,
+		}
+		if !item.Expires.IsZero() {
+			expires := item.Expires
+			cookie.Expires = &expires
+		}
+		r.Cookies = append(r.Cookies, cookie)
+	}
+	return r
+}
+
+// timings returns the HAR timings, end is when the body was read
+func (tr *harTrace) timings(end time.Time) *HARTimings {
+	t := &HARTimings{Blocked: -1, DNS: -1, Connect: -1, SSL: -1, Send: -1, Wait: -1, Receive: -1}
+	if !tr.dnsStart.IsZero() && !tr.dnsDone.IsZero() {
+		t.DNS = msSince(tr.dnsStart, tr.dnsDone)
+	}
+	if !tr.connectStart.IsZero() && !tr.connectDone.IsZero() {
+		t.Connect = msSince(tr.connectStart, tr.connectDone)
+	}
+	if !tr.tlsStart.IsZero() && !tr.tlsDone.IsZero() {
+		t.SSL = msSince(tr.tlsStart, tr.tlsDone)
+		// connect includes ssl in HAR 1.2
+		if t.Connect >= 0 {
+			t.Connect += t.SSL
+		}
+	}
+	if !tr.wroteRequest.IsZero() {
+		t.Send = 0
+		if !tr.firstByte.IsZero() {
+			t.Wait = msSince(tr.wroteRequest, tr.firstByte)
+			t.Receive = msSince(tr.firstByte, end)
+		}
+	}
+	return t
+}
+
+// harBody read the response body and report it when finished
+type harBody struct {
+	io.ReadCloser
+	limit    int
+	buf      []byte
+	size     int64
+	finished bool
+	done     func(b []byte, size int64)
+}
+
+func (b *harBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.size += int64(n)
+	if room := b.limit - len(b.buf); room > 0 && n > 0 {
+		if n < room {
+			room = n
+		}
+		b.buf = append(b.buf, p[:room]...)
+	}
+	if err == io.EOF {
+		b.finish()
+	}
+	return n, err
+}
+
+func (b *harBody) Close() error {
+	b.finish()
+	return b.ReadCloser.Close()
+}
+
+func (b *harBody) finish() {
+	if b.finished {
+		return
+	}
+	b.finished = true
+	b.done(b.buf, b.size)
+}
+
+func harHeaders(header http.Header) []*HARNameValue {
+	list := make([]*HARNameValue, 0, len(header))
+	for key, values := range header {
+		for _, value := range values {
+			list = append(list, &HARNameValue{Name: key, Value: value})
+		}
+	}
+	return list
+}
+
+// maskProxy hide the password of a proxy url
+func maskProxy(proxy string) string {
+	u, err := url.Parse(proxy)
+	if err != nil || u.User == nil {
+		return proxy
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "xxxxx")
+	}
+	return u.String()
+}
+
+func splitHostPort(addr string) (string, string) {
+	i := strings.LastIndex(addr, ":")
+	if i < 0 {
+		return strings.Trim(addr, "[]"), ""
+	}
+	return strings.Trim(addr[:i], "[]"), addr[i+1:]
+}
+
+func msSince(start, end time.Time) float64 {
+	return float64(end.Sub(start)) / float64(time.Millisecond)
+}
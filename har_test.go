@@ -0,0 +1,74 @@
+package esme
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_HARRecorder(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" {
+			http.SetCookie(w, &http.Cookie{Name: "sid", Value: "abc", HttpOnly: true})
+			http.Redirect(w, r, "/home", http.StatusFound)
+			return
+		}
+		_, _ = fmt.Fprint(w, "welcome home")
+	}))
+	defer ts.Close()
+
+	recorder := NewHARRecorder()
+	recorder.MaxBodySize = 7
+	queue := NewMemQueue()
+	queue.Add(&Task{Url: ts.URL + "/login?from=app", Method: "POST", FormData: FormData{"user": "sam"}})
+	NewJob("har", 1, queue, JobOptions{HAR: recorder}).Do()
+
+	har := recorder.HAR()
+	if len(har.Log.Entries) != 2 {
+		t.Fatalf("entries: got %d", len(har.Log.Entries))
+	}
+	login, home := har.Log.Entries[0], har.Log.Entries[1]
+	if login.Response.Status != 302 || login.Response.RedirectURL != "/home" || len(login.Response.Cookies) != 1 {
+		t.Fatalf("login response: got %+v", login.Response)
+	}
+	if login.Request.PostData == nil || login.Request.PostData.Text != "user=sa" || login.Request.QueryString[0].Value != "app" {
+		t.Fatalf("login request: got %+v", login.Request)
+	}
+	if home.Response.Content.Text != "welcome" || home.Response.Content.Size != 12 || home.ServerIPAddress != "127.0.0.1" {
+		t.Fatalf("home: got %+v %+v", home.Response.Content, home)
+	}
+
+	var buf bytes.Buffer
+	if _, err := recorder.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	tasks, err := ParseHAR(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 2 || tasks[0].Method != "POST" || tasks[1].Url != ts.URL+"/home" {
+		b, _ := json.Marshal(tasks)
+		t.Fatalf("tasks: got %s", b)
+	}
+}
+
+func Test_ParseHAR(t *testing.T) {
+	tasks, err := ParseHAR([]byte(`{"log":{"version":"1.2","entries":[{"request":{
+"method":"POST","url":"https://api.example.com/list","httpVersion":"h2",
+"headers":[{"name":":authority","value":"api.example.com"},{"name":"x-token","value":"t1"},{"name":"content-length","value":"6"}],
+"cookies":[{"name":"sid","value":"abc"}],
+"postData":{"mimeType":"application/x-www-form-urlencoded","params":[{"name":"page","value":"1"}]}}}]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	task := tasks[0]
+	if task.Header.Get("X-Token") != "t1" || task.Header.Get("Cookie") != "sid=abc" || task.Header.Get("Content-Length") != "" {
+		t.Fatalf("header: got %v", task.Header)
+	}
+	if task.FormData["page"] != "1" {
+		t.Fatalf("form data: got %v", task.FormData)
+	}
+}
@@ -0,0 +1,77 @@
+/*
+html.go
+parse the response body as html and query it with css selectors
+*/
+
+package esme
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/zituocn/esme/logx"
+)
+
+// HTMLDocument parsed html document of the response
+//	supports goquery methods like Find Attr Text Each
+type HTMLDocument struct {
+	*goquery.Document
+}
+
+// AbsURL resolve ref against the final url of the response
+//	returns "" when ref can not be parsed
+func (d *HTMLDocument) AbsURL(ref string) string {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return ""
+	}
+	u, err := url.Parse(ref)
+	if err != nil {
+		return ""
+	}
+	if d.Url == nil {
+		return u.String()
+	}
+	return d.Url.ResolveReference(u).String()
+}
+
+// AttrURL returns the attribute of the first element in s as an absolute url
+//	like: doc.AttrURL(doc.Find("a.next"), "href")
+func (d *HTMLDocument) AttrURL(s *goquery.Selection, attr string) string {
+	v, ok := s.Attr(attr)
+	if !ok {
+		return ""
+	}
+	return d.AbsURL(v)
+}
+
+// HTML returns the parsed html document of the response body
+//	the body is converted to utf-8 before parsing and the document is cached
+//	relative urls are resolved against the final url of the response
+func (c *Context) HTML() *HTMLDocument {
+	if c.htmlDoc != nil {
+		return c.htmlDoc
+	}
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(c.ToUTF8()))
+	if err != nil {
+		logx.Errorf("parse html error: %s", err.Error())
+		doc, _ = goquery.NewDocumentFromReader(strings.NewReader(""))
+	}
+	doc.Url = c.FinalURL()
+	c.htmlDoc = &HTMLDocument{Document: doc}
+	return c.htmlDoc
+}
+
+// FinalURL returns the url of the last request
+//	after following redirects
+func (c *Context) FinalURL() *url.URL {
+	if c.Response != nil && c.Response.Request != nil {
+		return c.Response.Request.URL
+	}
+	if c.Request != nil {
+		return c.Request.URL
+	}
+	return nil
+}
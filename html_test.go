@@ -0,0 +1,55 @@
+package esme
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+func Test_ContextHTML(t *testing.T) {
+	page := `<html><head><meta charset="gbk"><title>天气预报</title></head><body>
+	<ul class="city"><li><a href="/wether/bj">北京</a></li><li><a href="cd?x=1">成都</a></li></ul>
+	</body></html>`
+	body, _ := simplifiedchinese.GBK.NewEncoder().String(page)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/list" {
+			http.Redirect(w, r, "/city/index.html", http.StatusFound)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=gbk")
+		_, _ = w.Write([]byte(body))
+	}))
+	defer ts.Close()
+
+	ctx := HttpGet(ts.URL + "/list")
+	ctx.Do()
+
+	if cs := ctx.Charset(); cs != "gbk" {
+		t.Fatalf("charset: got %s", cs)
+	}
+	doc := ctx.HTML()
+	if doc != ctx.HTML() {
+		t.Fatal("document is not cached")
+	}
+	if title := doc.Find("title").Text(); title != "天气预报" {
+		t.Fatalf("title: got %s", title)
+	}
+
+	links := make([]string, 0)
+	doc.Find("ul.city a").Each(func(i int, s *goquery.Selection) {
+		links = append(links, doc.AttrURL(s, "href"))
+	})
+	want := []string{ts.URL + "/wether/bj", ts.URL + "/city/cd?x=1"}
+	if len(links) != len(want) {
+		t.Fatalf("links: got %v", links)
+	}
+	for i := range want {
+		if links[i] != want[i] {
+			t.Fatalf("link %d: got %s want %s", i, links[i], want[i])
+		}
+	}
+}
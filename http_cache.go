@@ -0,0 +1,409 @@
+/*
+http_cache.go
+http response cache with RFC 7234 semantics
+*/
+
+package esme
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zituocn/esme/logx"
+)
+
+// CacheMode how cached responses are used
+type CacheMode int
+
+const (
+
+	// CacheDefault follow Cache-Control and Expires,
+	//	stale responses are revalidated with If-None-Match / If-Modified-Since
+	CacheDefault CacheMode = iota
+
+	// CacheForce serve any cached response without checking freshness,
+	//	and store every cacheable status code whatever the headers say.
+	//	used to develop parsers offline against pages fetched once
+	CacheForce
+)
+
+// CacheStatus how the response of a request was served
+type CacheStatus string
+
+const (
+
+	// CacheNone the cache is not used
+	CacheNone CacheStatus = ""
+
+	// CacheMiss fetched from the network
+	CacheMiss CacheStatus = "miss"
+
+	// CacheHit served from the cache
+	CacheHit CacheStatus = "hit"
+
+	// CacheRevalidated the cached response was confirmed by a 304
+	CacheRevalidated CacheStatus = "revalidated"
+)
+
+var (
+
+	// cacheableStatus status codes cacheable by default
+	cacheableStatus = map[int]bool{
+		200: true, 203: true, 204: true, 300: true, 301: true,
+		404: true, 405: true, 410: true, 414: true, 501: true,
+	}
+)
+
+// SetCache set the response cache of the context
+func (c *Context) SetCache(cache Cache, mode CacheMode) *Context {
+	if cache == nil {
+		return c
+	}
+	c.cache = cache
+	c.cacheMode = mode
+	return c
+}
+
+// SetCacheIgnoreQuery set the query parameters left out of the cache key,
+//	like the timestamp and the signature of a ParamSigner
+func (c *Context) SetCacheIgnoreQuery(names ...string) *Context {
+	if len(names) == 0 {
+		return c
+	}
+	c.cacheIgnoreQuery = names
+	return c
+}
+
+// CacheStatus returns how the response was served by the cache
+func (c *Context) CacheStatus() CacheStatus {
+	return c.cacheStatus
+}
+
+// FromCache returns whether the response body came from the cache
+func (c *Context) FromCache() bool {
+	return c.cacheStatus == CacheHit || c.cacheStatus == CacheRevalidated
+}
+
+/*
+private
+*/
+
+// cacheEntry a cached response
+type cacheEntry struct {
+	StatusCode   int               `json:"status_code"`
+	Header       http.Header       `json:"header"`
+	Body         []byte            `json:"body"`
+	RequestTime  time.Time         `json:"request_time"`
+	ResponseTime time.Time         `json:"response_time"`
+	Vary         map[string]string `json:"vary"`
+}
+
+// cacheTransport serve requests from the cache
+type cacheTransport struct {
+	base  http.RoundTripper
+	cache Cache
+	mode  CacheMode
+
+	// ignoreQuery query parameters left out of the key
+	ignoreQuery []string
+}
+
+func (t *cacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if (req.Method != "GET" && req.Method != "HEAD") || hasCacheToken(req.Header, "no-store") {
+		return t.base.RoundTrip(req)
+	}
+
+	key := req.Method + " " + withoutQuery(req.URL.String(), t.ignoreQuery)
+	entry := t.load(key, req)
+	if entry != nil {
+		if t.mode == CacheForce || entry.fresh(req) {
+			setCacheStatus(req, CacheHit)
+			return entry.response(req), nil
+		}
+		req = conditionalRequest(req, entry)
+	}
+
+	requestTime := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	responseTime := time.Now()
+
+	if entry != nil && resp.StatusCode == http.StatusNotModified {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		_ = resp.Body.Close()
+		entry.merge(resp.Header, requestTime, responseTime)
+		t.store(key, entry)
+		setCacheStatus(req, CacheRevalidated)
+		return entry.response(req), nil
+	}
+
+	setCacheStatus(req, CacheMiss)
+	if !t.cacheable(req, resp) {
+		if entry != nil {
+			t.cache.Delete(key)
+		}
+		return resp, nil
+	}
+
+	// the body is bounded by the limits of the context, a larger one is not cached
+	body, err := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		if entry != nil {
+			t.cache.Delete(key)
+		}
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	entry = &cacheEntry{
+		StatusCode:   resp.StatusCode,
+		Header:       resp.Header.Clone(),
+		Body:         body,
+		RequestTime:  requestTime,
+		ResponseTime: responseTime,
+		Vary:         make(map[string]string),
+	}
+	for _, name := range varyHeaders(resp.Header) {
+		entry.Vary[name] = req.Header.Get(name)
+	}
+	t.store(key, entry)
+	return resp, nil
+}
+
+// load returns the cached entry matching the Vary headers of req
+func (t *cacheTransport) load(key string, req *http.Request) *cacheEntry {
+	b, ok := t.cache.Get(key)
+	if !ok {
+		return nil
+	}
+	entry := new(cacheEntry)
+	if err := json.Unmarshal(b, entry); err != nil {
+		logx.Errorf("decode cache entry failed : %v", err)
+		return nil
+	}
+	for name, value := range entry.Vary {
+		if req.Header.Get(name) != value {
+			return nil
+		}
+	}
+	return entry
+}
+
+func (t *cacheTransport) store(key string, entry *cacheEntry) {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		logx.Errorf("encode cache entry failed : %v", err)
+		return
+	}
+	t.cache.Set(key, b)
+}
+
+// cacheable returns whether resp may be stored
+func (t *cacheTransport) cacheable(req *http.Request, resp *http.Response) bool {
+	if req.Method != "GET" || !cacheableStatus[resp.StatusCode] {
+		return false
+	}
+
+	// the cache may be shared by users, responses of one user are not stored (RFC 7234 3, 3.2)
+	cc := parseCacheControl(resp.Header)
+	if _, ok := cc["private"]; ok {
+		return false
+	}
+	if req.Header.Get("Authorization") != "" {
+		_, public := cc["public"]
+		_, sMaxAge := cc["s-maxage"]
+		_, revalidate := cc["must-revalidate"]
+		if !public && !sMaxAge && !revalidate {
+			return false
+		}
+	}
+	if t.mode == CacheForce {
+		return true
+	}
+	if hasCacheToken(resp.Header, "no-store") {
+		return false
+	}
+	for _, name := range varyHeaders(resp.Header) {
+		if name == "*" {
+			return false
+		}
+	}
+	_, maxAge := cc["max-age"]
+	return maxAge || resp.Header.Get("Expires") != "" ||
+		resp.Header.Get("ETag") != "" || resp.Header.Get("Last-Modified") != ""
+}
+
+// fresh returns whether the entry can be served without revalidation
+func (e *cacheEntry) fresh(req *http.Request) bool {
+	reqCC := parseCacheControl(req.Header)
+	if _, ok := reqCC["no-cache"]; ok {
+		return false
+	}
+	age := e.age()
+	if v, ok := reqCC["max-age"]; ok {
+		if maxAge, err := strconv.Atoi(v); err == nil && age > time.Duration(maxAge)*time.Second {
+			return false
+		}
+	}
+	return e.lifetime() > age
+}
+
+// lifetime freshness lifetime of the entry
+//	RFC 7234 4.2.1
+func (e *cacheEntry) lifetime() time.Duration {
+	cc := parseCacheControl(e.Header)
+	if _, ok := cc["no-cache"]; ok {
+		return 0
+	}
+	if v, ok := cc["max-age"]; ok {
+		maxAge, err := strconv.Atoi(v)
+		if err != nil {
+			return 0
+		}
+		return time.Duration(maxAge) * time.Second
+	}
+	date := e.date()
+	if v := e.Header.Get("Expires"); v != "" {
+		expires, err := http.ParseTime(v)
+		if err != nil {
+			// an invalid Expires means already expired
+			return 0
+		}
+		return expires.Sub(date)
+	}
+
+	// heuristic freshness, 10% of the time since the last modification
+	if v := e.Header.Get("Last-Modified"); v != "" && e.StatusCode == http.StatusOK {
+		if lastModified, err := http.ParseTime(v); err == nil && date.After(lastModified) {
+			return date.Sub(lastModified) / 10
+		}
+	}
+	return 0
+}
+
+// age current age of the entry
+//	RFC 7234 4.2.3
+func (e *cacheEntry) age() time.Duration {
+	apparentAge := e.ResponseTime.Sub(e.date())
+	if apparentAge < 0 {
+		apparentAge = 0
+	}
+	ageValue := time.Duration(0)
+	if v, err := strconv.Atoi(e.Header.Get("Age")); err == nil {
+		ageValue = time.Duration(v) * time.Second
+	}
+	correctedAge := ageValue + e.ResponseTime.Sub(e.RequestTime)
+	if apparentAge > correctedAge {
+		correctedAge = apparentAge
+	}
+	return correctedAge + time.Since(e.ResponseTime)
+}
+
+// date returns the Date header, the response time when missing
+func (e *cacheEntry) date() time.Time {
+	if date, err := http.ParseTime(e.Header.Get("Date")); err == nil {
+		return date
+	}
+	return e.ResponseTime
+}
+
+// merge update the entry with the headers of a 304 response
+func (e *cacheEntry) merge(header http.Header, requestTime, responseTime time.Time) {
+	for name, values := range header {
+		switch http.CanonicalHeaderKey(name) {
+		case "Content-Length", "Content-Encoding", "Transfer-Encoding":
+			continue
+		}
+		e.Header[name] = values
+	}
+	e.RequestTime = requestTime
+	e.ResponseTime = responseTime
+}
+
+// response returns a new *http.Response of the entry
+func (e *cacheEntry) response(req *http.Request) *http.Response {
+	header := e.Header.Clone()
+	header.Set("Age", strconv.Itoa(int(e.age().Seconds())))
+	body := e.Body
+	if req.Method == "HEAD" {
+		body = nil
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", e.StatusCode, http.StatusText(e.StatusCode)),
+		StatusCode:    e.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(e.Body)),
+		Request:       req,
+	}
+}
+
+// conditionalRequest returns a copy of req revalidating entry
+func conditionalRequest(req *http.Request, entry *cacheEntry) *http.Request {
+	req = req.Clone(req.Context())
+	if etag := entry.Header.Get("ETag"); etag != "" && req.Header.Get("If-None-Match") == "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastModified := entry.Header.Get("Last-Modified"); lastModified != "" && req.Header.Get("If-Modified-Since") == "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+	return req
+}
+
+func setCacheStatus(req *http.Request, status CacheStatus) {
+	if c := contextFromRequest(req); c != nil {
+		c.cacheStatus = status
+	}
+}
+
+// parseCacheControl returns the directives of the Cache-Control header
+//	like: max-age=60, no-cache -> {"max-age": "60", "no-cache": ""}
+func parseCacheControl(header http.Header) map[string]string {
+	cc := make(map[string]string)
+	for _, line := range header.Values("Cache-Control") {
+		for _, item := range strings.Split(line, ",") {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+			kvs := strings.SplitN(item, "=", 2)
+			key := strings.ToLower(strings.TrimSpace(kvs[0]))
+			if len(kvs) == 2 {
+				cc[key] = strings.Trim(strings.TrimSpace(kvs[1]), `"`)
+			} else {
+				cc[key] = ""
+			}
+		}
+	}
+	return cc
+}
+
+func hasCacheToken(header http.Header, token string) bool {
+	_, ok := parseCacheControl(header)[token]
+	return ok || (token == "no-cache" && strings.Contains(strings.ToLower(header.Get("Pragma")), "no-cache"))
+}
+
+func varyHeaders(header http.Header) []string {
+	names := make([]string, 0)
+	for _, line := range header.Values("Vary") {
+		for _, item := range strings.Split(line, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				names = append(names, http.CanonicalHeaderKey(item))
+			}
+		}
+	}
+	return names
+}
@@ -0,0 +1,132 @@
+package esme
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func Test_ContextCache(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		switch r.URL.Path {
+		case "/fresh":
+			w.Header().Set("Cache-Control", "max-age=60")
+		case "/etag":
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("ETag", `"v1"`)
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		case "/no-store":
+			w.Header().Set("Cache-Control", "no-store")
+		}
+		_, _ = fmt.Fprint(w, r.URL.Path)
+	}))
+	defer ts.Close()
+
+	cases := []struct {
+		path  string
+		mode  CacheMode
+		want  []CacheStatus
+		total int32
+	}{
+		{"/fresh", CacheDefault, []CacheStatus{CacheMiss, CacheHit, CacheHit}, 1},
+		{"/etag", CacheDefault, []CacheStatus{CacheMiss, CacheRevalidated, CacheRevalidated}, 3},
+		{"/no-store", CacheDefault, []CacheStatus{CacheMiss, CacheMiss, CacheMiss}, 3},
+		{"/no-store", CacheForce, []CacheStatus{CacheMiss, CacheHit, CacheHit}, 1},
+	}
+	for _, item := range cases {
+		cache := NewDiskCache(t.TempDir())
+		atomic.StoreInt32(&hits, 0)
+		for i, want := range item.want {
+			ctx := HttpGet(ts.URL+item.path).SetCache(cache, item.mode)
+			ctx.Do()
+			if ctx.CacheStatus() != want {
+				t.Fatalf("%s %d: got %s want %s", item.path, i, ctx.CacheStatus(), want)
+			}
+			if ctx.ToString() != item.path {
+				t.Fatalf("%s %d: body %s", item.path, i, ctx.ToString())
+			}
+		}
+		if hits != item.total {
+			t.Fatalf("%s: server hits %d want %d", item.path, hits, item.total)
+		}
+	}
+}
+
+func Test_CacheMaxBodySize(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		for i := 0; i < 64; i++ {
+			_, _ = w.Write(bytes.Repeat([]byte("a"), 16*1024))
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer ts.Close()
+
+	cache := NewMemCache()
+	ctx := HttpGet(ts.URL).SetCache(cache, CacheDefault).SetMaxBodySize(1024)
+	var reqErr *RequestError
+	if err := ctx.Do(); !errors.As(err, &reqErr) || reqErr.Kind != ErrorLimit {
+		t.Fatalf("err %v", err)
+	}
+	if _, ok := cache.Get("GET " + ts.URL); ok {
+		t.Fatal("a response over the limit is cached")
+	}
+
+	ctx = HttpGet(ts.URL).SetCache(cache, CacheDefault)
+	if err := ctx.Do(); err != nil || ctx.CacheStatus() != CacheMiss || len(ctx.RespBody) != 1<<20 {
+		t.Fatalf("err %v status %s size %d", err, ctx.CacheStatus(), len(ctx.RespBody))
+	}
+}
+
+func Test_CacheCredentials(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/private":
+			w.Header().Set("Cache-Control", "private, max-age=60")
+		case "/public":
+			w.Header().Set("Cache-Control", "public, max-age=60")
+		default:
+			w.Header().Set("Cache-Control", "max-age=60")
+		}
+		_, _ = fmt.Fprint(w, r.Header.Get("Authorization"))
+	}))
+	defer ts.Close()
+
+	cases := []struct {
+		path  string
+		token string
+		mode  CacheMode
+		want  CacheStatus
+	}{
+		{"/private", "", CacheDefault, CacheMiss},
+		{"/private", "", CacheForce, CacheMiss},
+		{"/auth", "alice", CacheDefault, CacheMiss},
+		{"/auth", "alice", CacheForce, CacheMiss},
+		{"/public", "alice", CacheDefault, CacheHit},
+		{"/anonymous", "", CacheDefault, CacheHit},
+	}
+	for _, item := range cases {
+		cache := NewMemCache()
+		for i := 0; i < 2; i++ {
+			ctx := HttpGet(ts.URL+item.path).SetCache(cache, item.mode)
+			if item.token != "" {
+				ctx.SetAuth(&BearerAuth{Token: item.token})
+			}
+			if err := ctx.Do(); err != nil {
+				t.Fatal(err)
+			}
+			if i == 1 && ctx.CacheStatus() != item.want {
+				t.Fatalf("%s %d: got %s want %s", item.path, item.mode, ctx.CacheStatus(), item.want)
+			}
+		}
+	}
+}
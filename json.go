@@ -0,0 +1,112 @@
+/*
+json.go
+json request bodies and typed json responses
+*/
+
+package esme
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+const (
+	jsonContentType = "application/json; charset=utf-8"
+
+	// statusErrorBodySize length of the body in the message of StatusError
+	statusErrorBodySize = 200
+)
+
+// JSONBody json request body, created by JSON
+type JSONBody struct {
+	value interface{}
+}
+
+// JSON returns a request body of v encoded as json
+//	the request gets Content-Type and Accept headers of json
+//	like: esme.HttpPost(url, esme.JSON(user))
+func JSON(v interface{}) *JSONBody {
+	return &JSONBody{value: v}
+}
+
+// StatusError the response of a request has a non-2xx status code
+type StatusError struct {
+	Method string
+
+	URL string
+
+	StatusCode int
+
+	Status string
+
+	Header http.Header
+
+	// Body response body
+	Body []byte
+}
+
+// Error returns the error message with the beginning of the body
+func (e *StatusError) Error() string {
+	body := e.Body
+	if len(body) > statusErrorBodySize {
+		body = body[:statusErrorBodySize]
+	}
+	return fmt.Sprintf("%s %s: %s: %s", e.Method, e.URL, e.Status, body)
+}
+
+// DoJSON execute the request of ctx and decode the json response into a T
+//	returns *StatusError when the status code is not 2xx
+func DoJSON[T any](ctx *Context) (T, error) {
+	var v T
+	if ctx == nil {
+		return v, errors.New("esme: nil context")
+	}
+	if err := ctx.Do(); err != nil {
+		return v, err
+	}
+	if ctx.Response.StatusCode < 200 || ctx.Response.StatusCode > 299 {
+		return v, &StatusError{
+			Method:     ctx.Request.Method,
+			URL:        ctx.Request.URL.String(),
+			StatusCode: ctx.Response.StatusCode,
+			Status:     ctx.Response.Status,
+			Header:     ctx.Response.Header,
+			Body:       ctx.RespBody,
+		}
+	}
+	if len(ctx.RespBody) == 0 {
+		return v, nil
+	}
+	if err := json.Unmarshal(ctx.RespBody, &v); err != nil {
+		return v, fmt.Errorf("esme: decode json of %s: %w", ctx.Request.URL, err)
+	}
+	return v, nil
+}
+
+// GetJSON send a GET request and decode the json response into a T
+func GetJSON[T any](url string, vs ...interface{}) (T, error) {
+	ctx := DoRequest(url, "GET", vs...)
+	if ctx != nil && ctx.Request.Header.Get("Accept") == "" {
+		ctx.Request.Header.Set("Accept", "application/json")
+	}
+	return DoJSON[T](ctx)
+}
+
+// PostJSON send body as json and decode the json response into a T
+func PostJSON[T any](url string, body interface{}, vs ...interface{}) (T, error) {
+	return DoJSON[T](DoRequest(url, "POST", append(vs, JSON(body))...))
+}
+
+/*
+private
+*/
+
+// encode returns the json of the body
+func (b *JSONBody) encode() ([]byte, error) {
+	if raw, ok := b.value.(json.RawMessage); ok {
+		return raw, nil
+	}
+	return json.Marshal(b.value)
+}
@@ -0,0 +1,79 @@
+package esme
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type jsonUser struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+func Test_JSON(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error":"not found"}`))
+			return
+		}
+		if r.Header.Get("Content-Type") != jsonContentType || r.Header.Get("Accept") != "application/json" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		user := new(jsonUser)
+		if err := json.Unmarshal(body, user); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		user.ID++
+		w.Header().Set("Content-Type", jsonContentType)
+		_ = json.NewEncoder(w).Encode(user)
+	}))
+	defer ts.Close()
+
+	user, err := PostJSON[jsonUser](ts.URL, &jsonUser{ID: 1, Name: "esme"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != 2 || user.Name != "esme" {
+		t.Fatalf("got %+v", user)
+	}
+
+	_, err = GetJSON[map[string]string](ts.URL + "/missing")
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) {
+		t.Fatalf("want *StatusError, got %v", err)
+	}
+	if statusErr.StatusCode != http.StatusNotFound || string(statusErr.Body) != `{"error":"not found"}` {
+		t.Fatalf("got %+v", statusErr)
+	}
+
+	// Task.JSON in a queue
+	task := &Task{Url: ts.URL, Method: "POST", JSON: json.RawMessage(`{"id":5,"name":"job"}`)}
+	b, err := json.Marshal(task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	task = new(Task)
+	if err = json.Unmarshal(b, task); err != nil {
+		t.Fatal(err)
+	}
+	var got *jsonUser
+	job := NewJob("json", 1, NewMemQueue(), JobOptions{
+		SucceedFunc: func(ctx *Context) {
+			got = new(jsonUser)
+			_ = ctx.ToJSON(got)
+		},
+	})
+	job.queue.Add(task)
+	job.Do()
+	if got == nil || got.ID != 6 || got.Name != "job" {
+		t.Fatalf("got %+v", got)
+	}
+}
@@ -0,0 +1,251 @@
+/*
+limits.go
+response size limits, content-type allowlist and bandwidth throttling
+*/
+
+package esme
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+
+	// throttleChunkSize max bytes of one read of a throttled body
+	throttleChunkSize = 16 * 1024
+)
+
+// BodySizeError the response body is larger than MaxBodySize
+type BodySizeError struct {
+
+	// Limit max body size
+	Limit int64
+
+	// ContentLength Content-Length of the response, -1 when unknown
+	ContentLength int64
+}
+
+// Error returns the error message
+func (e *BodySizeError) Error() string {
+	if e.ContentLength > 0 {
+		return fmt.Sprintf("response body of %d bytes exceeds the limit of %d bytes", e.ContentLength, e.Limit)
+	}
+	return fmt.Sprintf("response body exceeds the limit of %d bytes", e.Limit)
+}
+
+// ContentTypeError the content type of the response is not allowed
+type ContentTypeError struct {
+
+	// ContentType Content-Type of the response
+	ContentType string
+
+	// Allowed content types allowed
+	Allowed []string
+}
+
+// Error returns the error message
+func (e *ContentTypeError) Error() string {
+	return fmt.Sprintf("content type %q not allowed, allowed: %s", e.ContentType, strings.Join(e.Allowed, ", "))
+}
+
+// Bandwidth limit the transfer rate of request and response bodies
+//	share one *Bandwidth between contexts to limit them together
+type Bandwidth struct {
+	mux sync.Mutex
+
+	// rate bytes per second
+	rate float64
+
+	// tokens bytes available, negative when reserved in advance
+	tokens float64
+
+	last time.Time
+}
+
+// NewBandwidth returns a *Bandwidth of bytesPerSecond
+func NewBandwidth(bytesPerSecond int64) *Bandwidth {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	return &Bandwidth{
+		rate:   float64(bytesPerSecond),
+		tokens: float64(bytesPerSecond),
+		last:   time.Now(),
+	}
+}
+
+// SetMaxBodySize set the max size of the response body in bytes
+//	a larger response stops with *BodySizeError before the whole body is read
+func (c *Context) SetMaxBodySize(size int64) *Context {
+	if size <= 0 {
+		return c
+	}
+	c.maxBodySize = size
+	return c
+}
+
+// SetAllowedContentTypes only read responses of these content types
+//	other responses stop with *ContentTypeError before the body is read.
+//	like: "text/html", "application/json", "text/*"
+func (c *Context) SetAllowedContentTypes(types ...string) *Context {
+	if len(types) == 0 {
+		return c
+	}
+	c.allowedTypes = types
+	return c
+}
+
+// SetBandwidth limit the transfer rate of the bodies of the request
+func (c *Context) SetBandwidth(limits ...*Bandwidth) *Context {
+	for _, item := range limits {
+		if item != nil {
+			c.bandwidths = append(c.bandwidths, item)
+		}
+	}
+	return c
+}
+
+/*
+private
+*/
+
+// wait reserve n bytes and wait until they are available
+func (b *Bandwidth) wait(n int) {
+	b.mux.Lock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	var delay time.Duration
+	if b.tokens < 0 {
+		delay = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	b.mux.Unlock()
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// limitTransport apply the limits of the context to the responses of base
+//	the innermost transport, so the cassette, the cache and the HAR recorder
+//	only read bodies within the limits and at the rate of the bandwidths
+type limitTransport struct {
+	base http.RoundTripper
+	ctx  *Context
+}
+
+func (t *limitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if err = t.ctx.checkResponse(resp); err != nil {
+		_ = resp.Body.Close()
+		return nil, newRequestError(ErrorLimit, err)
+	}
+	resp.Body = t.ctx.throttle(resp.Body)
+	if t.ctx.maxBodySize > 0 && resp.Body != nil && resp.Body != http.NoBody {
+		resp.Body = &limitedReadCloser{
+			limitedBody: &limitedBody{r: resp.Body, limit: t.ctx.maxBodySize, size: resp.ContentLength},
+			Closer:      resp.Body,
+		}
+	}
+	return resp, nil
+}
+
+// checkResponse returns the limit error of resp before its body is read
+//	the content type of redirects and 304 responses is not checked
+func (c *Context) checkResponse(resp *http.Response) error {
+	if resp.StatusCode < 300 || resp.StatusCode >= 400 {
+		if err := c.checkContentType(resp.Header); err != nil {
+			return err
+		}
+	}
+	if c.maxBodySize > 0 && resp.ContentLength > c.maxBodySize {
+		return &BodySizeError{Limit: c.maxBodySize, ContentLength: resp.ContentLength}
+	}
+	return nil
+}
+
+// checkContentType returns *ContentTypeError when the content type is not allowed
+func (c *Context) checkContentType(header http.Header) error {
+	if len(c.allowedTypes) == 0 {
+		return nil
+	}
+	contentType := header.Get("Content-Type")
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = strings.ToLower(strings.TrimSpace(contentType))
+	}
+	for _, item := range c.allowedTypes {
+		item = strings.ToLower(item)
+		if item == mediaType || (strings.HasSuffix(item, "/*") && strings.HasPrefix(mediaType, item[:len(item)-1])) {
+			return nil
+		}
+	}
+	return &ContentTypeError{ContentType: contentType, Allowed: c.allowedTypes}
+}
+
+// throttle returns r limited by the bandwidths of the context
+func (c *Context) throttle(r io.ReadCloser) io.ReadCloser {
+	if len(c.bandwidths) == 0 || r == nil || r == http.NoBody {
+		return r
+	}
+	return &throttledBody{ReadCloser: r, limits: c.bandwidths}
+}
+
+// throttledBody a body read at the rate of its limits
+type throttledBody struct {
+	io.ReadCloser
+	limits []*Bandwidth
+}
+
+func (b *throttledBody) Read(p []byte) (int, error) {
+	// small reads keep the transfer smooth
+	if len(p) > throttleChunkSize {
+		p = p[:throttleChunkSize]
+	}
+	n, err := b.ReadCloser.Read(p)
+	for _, item := range b.limits {
+		item.wait(n)
+	}
+	return n, err
+}
+
+// limitedBody a body returning *BodySizeError after limit bytes
+type limitedBody struct {
+	r     io.Reader
+	limit int64
+	read  int64
+	size  int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.read > b.limit {
+		return 0, &BodySizeError{Limit: b.limit, ContentLength: b.size}
+	}
+	if remain := b.limit + 1 - b.read; int64(len(p)) > remain {
+		p = p[:remain]
+	}
+	n, err := b.r.Read(p)
+	b.read += int64(n)
+	if b.read > b.limit {
+		return n, &BodySizeError{Limit: b.limit, ContentLength: b.size}
+	}
+	return n, err
+}
+
+// limitedReadCloser a limitedBody closing the original body
+type limitedReadCloser struct {
+	*limitedBody
+	io.Closer
+}
@@ -0,0 +1,127 @@
+package esme
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_MaxBodySize(t *testing.T) {
+	var zipped bytes.Buffer
+	zw := gzip.NewWriter(&zipped)
+	_, _ = zw.Write(bytes.Repeat([]byte("a"), 1<<20))
+	_ = zw.Close()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/large":
+			_, _ = w.Write(bytes.Repeat([]byte("a"), 2048))
+		case "/chunked":
+			for i := 0; i < 4; i++ {
+				_, _ = w.Write(bytes.Repeat([]byte("a"), 512))
+				w.(http.Flusher).Flush()
+			}
+		case "/gzip":
+			w.Header().Set("Content-Encoding", "gzip")
+			_, _ = w.Write(zipped.Bytes())
+		default:
+			_, _ = w.Write([]byte("small"))
+		}
+	}))
+	defer ts.Close()
+
+	for _, path := range []string{"/large", "/chunked", "/gzip"} {
+		ctx := HttpGet(ts.URL + path).SetMaxBodySize(1024)
+		err := ctx.Do()
+		var reqErr *RequestError
+		var sizeErr *BodySizeError
+		if !errors.As(err, &reqErr) || reqErr.Kind != ErrorLimit || !errors.As(err, &sizeErr) || sizeErr.Limit != 1024 {
+			t.Fatalf("%s: err %v", path, err)
+		}
+		if ctx.Outcome() != OutcomeError {
+			t.Fatalf("%s: outcome %s", path, ctx.Outcome())
+		}
+	}
+
+	ctx := HttpGet(ts.URL + "/small").SetMaxBodySize(5)
+	if err := ctx.Do(); err != nil || ctx.ToString() != "small" {
+		t.Fatalf("err %v body %s", err, ctx.ToString())
+	}
+}
+
+func Test_LimitTransport(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 64; i++ {
+			_, _ = w.Write(bytes.Repeat([]byte("a"), 16*1024))
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer ts.Close()
+
+	// the HAR recorder wraps the limits, it only sees the bounded body
+	recorder := NewHARRecorder()
+	ctx := HttpGet(ts.URL).SetMaxBodySize(1024).SetHAR(recorder)
+	var reqErr *RequestError
+	if err := ctx.Do(); !errors.As(err, &reqErr) || reqErr.Kind != ErrorLimit {
+		t.Fatalf("err %v", err)
+	}
+	entries := recorder.HAR().Log.Entries
+	if len(entries) != 1 || entries[0].Response.Content.Size > 1025 {
+		t.Fatalf("entries: got %+v", entries)
+	}
+}
+
+func Test_AllowedContentTypes(t *testing.T) {
+	read := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/image" {
+			w.Header().Set("Content-Type", "image/png")
+			read = true
+		} else {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		}
+		_, _ = w.Write([]byte("body"))
+	}))
+	defer ts.Close()
+
+	ctx := HttpGet(ts.URL + "/page").SetAllowedContentTypes("text/*", "application/json")
+	if err := ctx.Do(); err != nil || ctx.ToString() != "body" {
+		t.Fatalf("err %v body %s", err, ctx.ToString())
+	}
+
+	ctx = HttpGet(ts.URL + "/image").SetAllowedContentTypes("text/html")
+	err := ctx.Do()
+	var typeErr *ContentTypeError
+	if !read || !errors.As(err, &typeErr) || typeErr.ContentType != "image/png" || ctx.RespBody != nil {
+		t.Fatalf("err %v body %s", err, ctx.RespBody)
+	}
+	if !strings.Contains(err.Error(), "not allowed") {
+		t.Fatalf("message %s", err.Error())
+	}
+}
+
+func Test_Bandwidth(t *testing.T) {
+	if NewBandwidth(0) != nil {
+		t.Fatal("zero bandwidth should be unlimited")
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(bytes.Repeat([]byte("a"), 3000))
+	}))
+	defer ts.Close()
+
+	// the first second of the bucket is free, the rest takes about 2s at 1000 B/s
+	limit := NewBandwidth(1000)
+	start := time.Now()
+	ctx := HttpGet(ts.URL).SetBandwidth(limit)
+	if err := ctx.Do(); err != nil || len(ctx.RespBody) != 3000 {
+		t.Fatalf("err %v len %d", err, len(ctx.RespBody))
+	}
+	if d := time.Since(start); d < 1500*time.Millisecond {
+		t.Fatalf("read in %v", d)
+	}
+}
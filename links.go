@@ -0,0 +1,135 @@
+/*
+links.go
+extract and normalize links from html responses
+*/
+
+package esme
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+var (
+
+	// linkAttrs elements and attributes that hold links
+	linkAttrs = []struct {
+		selector string
+		attr     string
+	}{
+		{"a[href]", "href"},
+		{"area[href]", "href"},
+		{"link[href]", "href"},
+		{"script[src]", "src"},
+		{"img[src]", "src"},
+		{"iframe[src]", "src"},
+		{"frame[src]", "src"},
+		{"source[src]", "src"},
+	}
+)
+
+// Links returns the normalized absolute links of the html response
+//	collected from a, link, script, img, srcset and meta refresh
+//	duplicates are removed, the order of appearance is kept
+func (c *Context) Links() []string {
+	doc := c.HTML()
+	base := doc.Url
+	if href, ok := doc.Find("base[href]").First().Attr("href"); ok {
+		if u, err := url.Parse(strings.TrimSpace(href)); err == nil {
+			if base != nil {
+				u = base.ResolveReference(u)
+			}
+			base = u
+		}
+	}
+
+	links := make([]string, 0)
+	seen := make(map[string]bool)
+	add := func(ref string) {
+		link, ok := NormalizeURL(base, ref)
+		if ok && !seen[link] {
+			seen[link] = true
+			links = append(links, link)
+		}
+	}
+
+	for _, item := range linkAttrs {
+		doc.Find(item.selector).Each(func(i int, s *goquery.Selection) {
+			add(s.AttrOr(item.attr, ""))
+		})
+	}
+	doc.Find("img[srcset], source[srcset]").Each(func(i int, s *goquery.Selection) {
+		for _, ref := range parseSrcset(s.AttrOr("srcset", "")) {
+			add(ref)
+		}
+	})
+	doc.Find("meta[http-equiv]").Each(func(i int, s *goquery.Selection) {
+		if strings.EqualFold(s.AttrOr("http-equiv", ""), "refresh") {
+			add(parseMetaRefresh(s.AttrOr("content", "")))
+		}
+	})
+	return links
+}
+
+// NormalizeURL resolve ref against base and normalize it
+//	only http and https links are accepted, fragments are removed,
+//	scheme and host are lowercased and default ports are dropped
+func NormalizeURL(base *url.URL, ref string) (string, bool) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" || strings.HasPrefix(ref, "#") {
+		return "", false
+	}
+	u, err := url.Parse(ref)
+	if err != nil {
+		return "", false
+	}
+	if base != nil {
+		u = base.ResolveReference(u)
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", false
+	}
+	host := strings.ToLower(u.Host)
+	if (u.Scheme == "http" && strings.HasSuffix(host, ":80")) || (u.Scheme == "https" && strings.HasSuffix(host, ":443")) {
+		host = host[:strings.LastIndex(host, ":")]
+	}
+	if host == "" {
+		return "", false
+	}
+	u.Host = host
+	u.Fragment = ""
+	u.RawFragment = ""
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	if u.RawQuery == "" {
+		u.ForceQuery = false
+	}
+	return u.String(), true
+}
+
+// parseSrcset returns the urls of a srcset attribute
+//	like: "a.jpg 1x, b.jpg 2x"
+func parseSrcset(s string) []string {
+	refs := make([]string, 0)
+	for _, item := range strings.Split(s, ",") {
+		fields := strings.Fields(item)
+		if len(fields) > 0 {
+			refs = append(refs, fields[0])
+		}
+	}
+	return refs
+}
+
+// parseMetaRefresh returns the url of a meta refresh content
+//	like: "5; url=/index.html"
+func parseMetaRefresh(s string) string {
+	i := strings.Index(strings.ToLower(s), "url=")
+	if i < 0 {
+		return ""
+	}
+	return strings.Trim(strings.TrimSpace(s[i+4:]), `'"`)
+}
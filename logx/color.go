@@ -39,6 +39,8 @@ type colorWriter struct {
 
 // WriteLog colorWrite WriteLog
 func (w *colorWriter) WriteLog(now time.Time, level int, b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
 	w.b = w.b[:0]
 	w.b = append(w.b, logColor[level]...)
 	w.b = append(w.b, b...)
@@ -48,5 +50,5 @@ func (w *colorWriter) WriteLog(now time.Time, level int, b []byte) (int, error)
 
 // WithColor use colorWriter
 func WithColor(w Writer) Writer {
-	return &colorWriter{writer: w}
+	return &colorWriter{mu: &sync.Mutex{}, writer: w}
 }
@@ -0,0 +1,98 @@
+/*
+middleware.go
+middleware chain around the http request of Context.Do
+*/
+
+package esme
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+)
+
+// Handler execute the request of the context,
+//	set ctx.Response and ctx.RespBody and return the request error
+type Handler func(ctx *Context) error
+
+// Middleware wrap a Handler
+//	a middleware can change the request before calling next,
+//	return without calling next (cache, mock) after setting the response,
+//	or inspect the response and the error returned by next.
+//	it runs for every attempt of the request, callbacks run after the chain
+type Middleware func(next Handler) Handler
+
+var (
+	middlewareMux sync.RWMutex
+
+	// middlewares global middlewares, run before the others
+	middlewares []Middleware
+)
+
+// Use add global middlewares, used by every Context
+//	global middlewares run first, then job middlewares, then context middlewares
+func Use(m ...Middleware) {
+	middlewareMux.Lock()
+	defer middlewareMux.Unlock()
+	middlewares = append(middlewares, m...)
+}
+
+// Use add middlewares to the context
+func (c *Context) Use(m ...Middleware) *Context {
+	for _, item := range m {
+		if item != nil {
+			c.middlewares = append(c.middlewares, item)
+		}
+	}
+	return c
+}
+
+// SetResponse set the response of the context without a request
+//	used by middlewares returning a response without calling next
+func (c *Context) SetResponse(statusCode int, header http.Header, body []byte) *Context {
+	if header == nil {
+		header = http.Header{}
+	}
+	c.Response = &http.Response{
+		Status:        fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode)),
+		StatusCode:    statusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       c.Request,
+	}
+	c.reset()
+	c.RespBody = body
+	return c
+}
+
+/*
+private
+*/
+
+// handler returns the middleware chain around the auth, the signer and send
+func (c *Context) handler() Handler {
+	middlewareMux.RLock()
+	chain := make([]Middleware, 0, len(middlewares)+len(c.middlewares))
+	chain = append(chain, middlewares...)
+	middlewareMux.RUnlock()
+	chain = append(chain, c.middlewares...)
+
+	// auth and the signer run after the middlewares, for every attempt
+	h := Handler(send)
+	if c.signer != nil {
+		h = signHandler(c.signer, h)
+	}
+	if c.auth != nil {
+		h = authHandler(c.auth, h)
+	}
+	for i := len(chain) - 1; i >= 0; i-- {
+		h = chain[i](h)
+	}
+	return h
+}
@@ -0,0 +1,84 @@
+package esme
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func Test_Middleware(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("token=" + r.Header.Get("X-Token")))
+	}))
+	defer ts.Close()
+
+	var (
+		mux   sync.Mutex
+		order []string
+	)
+	trace := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(ctx *Context) error {
+				mux.Lock()
+				order = append(order, name)
+				mux.Unlock()
+				return next(ctx)
+			}
+		}
+	}
+	Use(trace("global"))
+	defer func() {
+		middlewareMux.Lock()
+		middlewares = nil
+		middlewareMux.Unlock()
+	}()
+
+	// rewrite the request and inspect the response
+	var inspected bool
+	job := NewJob("middleware", 1, NewMemQueue(), JobOptions{
+		Middlewares: []Middleware{trace("job"), func(next Handler) Handler {
+			return func(ctx *Context) error {
+				ctx.Request.Header.Set("X-Token", "abc")
+				err := next(ctx)
+				inspected = err == nil && strings.Contains(ctx.ToString(), "abc")
+				return err
+			}
+		}},
+		StartFunc: func(ctx *Context) {
+			ctx.Use(trace("context"))
+		},
+	})
+	job.queue.Add(&Task{Url: ts.URL, Method: "GET"})
+	job.Do()
+	if strings.Join(order, ",") != "global,job,context" || !inspected {
+		t.Fatalf("order %v inspected %v", order, inspected)
+	}
+
+	// short-circuit with a mocked response
+	ctx := HttpGet(ts.URL + "/mock").Use(func(next Handler) Handler {
+		return func(ctx *Context) error {
+			ctx.SetResponse(http.StatusOK, http.Header{"Content-Type": {"application/json"}}, []byte(`{"mock":true}`))
+			return nil
+		}
+	})
+	var succeed bool
+	ctx.SetSucceedFunc(func(ctx *Context) { succeed = true })
+	ctx.Do()
+	if !succeed || ctx.ToSection("mock") != "true" {
+		t.Fatalf("got %s", ctx.ToString())
+	}
+
+	// an error returned by a middleware
+	ctx = HttpGet(ts.URL).Use(func(next Handler) Handler {
+		return func(ctx *Context) error {
+			return errors.New("blocked")
+		}
+	})
+	ctx.Do()
+	if ctx.Err == nil || ctx.Err.Error() != "blocked" || ctx.Response != nil {
+		t.Fatalf("err %v", ctx.Err)
+	}
+}
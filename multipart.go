@@ -0,0 +1,331 @@
+/*
+multipart.go
+multipart/form-data request body with streamed files
+*/
+
+package esme
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// MultipartForm multipart/form-data request body
+//	files are streamed when the request is sent, they are not read into memory.
+//	parts from disk are serialized with the task, parts from an io.Reader are not
+type MultipartForm struct {
+
+	// Parts text fields and files, in order
+	Parts []*MultipartPart `json:"parts"`
+
+	mux sync.Mutex
+
+	// boundary multipart boundary, created on first use
+	boundary string
+
+	// opened the form was opened once
+	opened bool
+}
+
+// MultipartPart a field or file of a MultipartForm
+type MultipartPart struct {
+
+	// Name form field name
+	Name string `json:"name"`
+
+	// Value value of a text field
+	Value string `json:"value,omitempty"`
+
+	// FilePath file sent from disk
+	FilePath string `json:"file_path,omitempty"`
+
+	// FileName file name sent to the server,
+	//	the base name of FilePath by default
+	FileName string `json:"file_name,omitempty"`
+
+	// ContentType content type of the part,
+	//	guessed from the file extension for files
+	ContentType string `json:"content_type,omitempty"`
+
+	// Reader content of the file, used when FilePath is empty.
+	//	a reader implementing io.Seeker is rewound for every attempt,
+	//	a form with other readers is sent once, it is not retried or redirected with its body
+	Reader io.Reader `json:"-"`
+}
+
+// NewMultipartForm returns a new *MultipartForm
+func NewMultipartForm() *MultipartForm {
+	return &MultipartForm{
+		Parts: make([]*MultipartPart, 0),
+	}
+}
+
+// AddField add a text field
+func (m *MultipartForm) AddField(name, value string) *MultipartForm {
+	return m.AddPart(&MultipartPart{Name: name, Value: value})
+}
+
+// AddFile add a file from disk
+func (m *MultipartForm) AddFile(name, path string) *MultipartForm {
+	return m.AddPart(&MultipartPart{Name: name, FilePath: path})
+}
+
+// AddReader add a file read from r
+func (m *MultipartForm) AddReader(name, fileName string, r io.Reader) *MultipartForm {
+	return m.AddPart(&MultipartPart{Name: name, FileName: fileName, Reader: r})
+}
+
+// AddPart add a part
+//	use it to set the content type of a part
+func (m *MultipartForm) AddPart(part *MultipartPart) *MultipartForm {
+	if part == nil {
+		return m
+	}
+	m.Parts = append(m.Parts, part)
+	return m
+}
+
+// ContentType returns the Content-Type header of the form
+func (m *MultipartForm) ContentType() string {
+	return "multipart/form-data; boundary=" + m.getBoundary()
+}
+
+// Reader returns a reader of the encoded form
+//	the form is written in a goroutine while it is read
+func (m *MultipartForm) Reader() io.ReadCloser {
+	return &lazyBody{open: m.open}
+}
+
+/*
+private
+*/
+
+func (m *MultipartForm) getBoundary() string {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if m.boundary == "" {
+		m.boundary = multipart.NewWriter(io.Discard).Boundary()
+	}
+	return m.boundary
+}
+
+// replayable returns whether the form can be sent more than once
+func (m *MultipartForm) replayable() bool {
+	for _, part := range m.Parts {
+		if part.FilePath == "" && part.Reader != nil {
+			if _, ok := part.Reader.(io.Seeker); !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// sendable returns whether the form was not opened or can be sent again
+func (m *MultipartForm) sendable() bool {
+	m.mux.Lock()
+	opened := m.opened
+	m.mux.Unlock()
+	return !opened || m.replayable()
+}
+
+// open start writing the form to a pipe
+func (m *MultipartForm) open() (io.ReadCloser, error) {
+	m.mux.Lock()
+	opened := m.opened
+	m.opened = true
+	m.mux.Unlock()
+	if opened && !m.replayable() {
+		return nil, errMultipartSent
+	}
+
+	boundary := m.getBoundary()
+	pr, pw := io.Pipe()
+	go func() {
+		w := multipart.NewWriter(pw)
+		err := w.SetBoundary(boundary)
+		for i := 0; err == nil && i < len(m.Parts); i++ {
+			err = m.Parts[i].write(w)
+		}
+		if err == nil {
+			err = w.Close()
+		}
+		_ = pw.CloseWithError(err)
+	}()
+	return pr, nil
+}
+
+// size returns the length of the encoded form, -1 when unknown
+func (m *MultipartForm) size() int64 {
+	boundary := m.getBoundary()
+	counter := &countWriter{}
+	w := multipart.NewWriter(counter)
+	_ = w.SetBoundary(boundary)
+	var total int64
+	for _, part := range m.Parts {
+		n := part.size()
+		if n < 0 {
+			return -1
+		}
+		if _, err := w.CreatePart(part.header()); err != nil {
+			return -1
+		}
+		total += n
+	}
+	_ = w.Close()
+	return total + counter.n
+}
+
+func (p *MultipartPart) isFile() bool {
+	return p.FilePath != "" || p.Reader != nil
+}
+
+func (p *MultipartPart) header() textproto.MIMEHeader {
+	h := make(textproto.MIMEHeader)
+	if !p.isFile() {
+		h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"`, escapeQuotes(p.Name)))
+		if p.ContentType != "" {
+			h.Set("Content-Type", p.ContentType)
+		}
+		return h
+	}
+
+	fileName := p.FileName
+	if fileName == "" {
+		fileName = filepath.Base(p.FilePath)
+	}
+	contentType := p.ContentType
+	if contentType == "" {
+		contentType = mime.TypeByExtension(filepath.Ext(fileName))
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, escapeQuotes(p.Name), escapeQuotes(fileName)))
+	h.Set("Content-Type", contentType)
+	return h
+}
+
+// size returns the length of the content, -1 when unknown
+func (p *MultipartPart) size() int64 {
+	switch {
+	case p.FilePath != "":
+		info, err := os.Stat(p.FilePath)
+		if err != nil {
+			return -1
+		}
+		return info.Size()
+	case p.Reader != nil:
+		// the whole content is sent, the reader is rewound before
+		seeker, ok := p.Reader.(io.Seeker)
+		if !ok {
+			return -1
+		}
+		offset, err := seeker.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return -1
+		}
+		end, err := seeker.Seek(0, io.SeekEnd)
+		if err != nil {
+			return -1
+		}
+		if _, err = seeker.Seek(offset, io.SeekStart); err != nil {
+			return -1
+		}
+		return end
+	default:
+		return int64(len(p.Value))
+	}
+}
+
+func (p *MultipartPart) write(w *multipart.Writer) error {
+	dst, err := w.CreatePart(p.header())
+	if err != nil {
+		return err
+	}
+	switch {
+	case p.FilePath != "":
+		f, err := os.Open(p.FilePath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(dst, f)
+		return err
+	case p.Reader != nil:
+		if seeker, ok := p.Reader.(io.Seeker); ok {
+			if _, err = seeker.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+		}
+		_, err = io.Copy(dst, p.Reader)
+		return err
+	default:
+		_, err = io.WriteString(dst, p.Value)
+		return err
+	}
+}
+
+// newMultipartRequest returns a request sending the form
+//	GetBody returns a new stream of the form for redirects and retries,
+//	or errMultipartSent when a reader of the form was already sent
+func newMultipartRequest(method, url string, form *MultipartForm) (*http.Request, error) {
+	req, err := http.NewRequest(method, url, form.Reader())
+	if err != nil {
+		return nil, err
+	}
+	req.GetBody = func() (io.ReadCloser, error) {
+		if !form.sendable() {
+			return nil, errMultipartSent
+		}
+		return form.Reader(), nil
+	}
+	req.ContentLength = form.size()
+	return req, nil
+}
+
+// errMultipartSent a form with a reader without io.Seeker is sent again
+var errMultipartSent = errors.New("multipart: a reader without io.Seeker can only be sent once")
+
+// lazyBody a body opened on the first read
+//	a body never read costs nothing, so GetBody can be called freely
+type lazyBody struct {
+	open func() (io.ReadCloser, error)
+	rc   io.ReadCloser
+	err  error
+}
+
+func (b *lazyBody) Read(p []byte) (int, error) {
+	if b.rc == nil && b.err == nil {
+		b.rc, b.err = b.open()
+	}
+	if b.err != nil {
+		return 0, b.err
+	}
+	return b.rc.Read(p)
+}
+
+func (b *lazyBody) Close() error {
+	if b.rc == nil {
+		b.err = errors.New("multipart: read on closed body")
+		return nil
+	}
+	return b.rc.Close()
+}
+
+type countWriter struct {
+	n int64
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
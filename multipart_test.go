@@ -0,0 +1,96 @@
+package esme
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func Test_MultipartForm(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		// the first request is retried
+		if atomic.AddInt32(&hits, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		b, _ := ioutil.ReadAll(file)
+		var n []byte
+		noteType := ""
+		if note, noteHeader, err := r.FormFile("note"); err == nil {
+			n, _ = ioutil.ReadAll(note)
+			noteType = noteHeader.Header.Get("Content-Type")
+		}
+		_, _ = fmt.Fprintf(w, "%s|%s|%s|%s|%s|%s|%d", r.FormValue("name"), header.Filename, header.Header.Get("Content-Type"),
+			b, n, noteType, r.ContentLength)
+	}))
+	defer ts.Close()
+
+	file := filepath.Join(t.TempDir(), "a.txt")
+	if err := ioutil.WriteFile(file, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// a reader with io.Seeker is rewound and sent again on retry, with its whole length
+	note := strings.NewReader(`{"a":1}`)
+	_, _ = note.Read(make([]byte, 2))
+	form := NewMultipartForm().
+		AddField("name", "esme").
+		AddFile("file", file).
+		AddPart(&MultipartPart{Name: "note", FileName: "note.json", ContentType: "application/json", Reader: note})
+	ctx := HttpPost(ts.URL, form).SetRetryFunc(func(ctx *Context) {})
+	ctx.Do()
+	if !strings.HasPrefix(ctx.ToString(), `esme|a.txt|text/plain; charset=utf-8|hello|{"a":1}|application/json|`) ||
+		strings.HasSuffix(ctx.ToString(), "|-1") {
+		t.Fatalf("got %s", ctx.ToString())
+	}
+
+	// a reader without io.Seeker is streamed once and not retried
+	atomic.StoreInt32(&hits, 0)
+	var retried, failed bool
+	form = NewMultipartForm().
+		AddFile("file", file).
+		AddReader("note", "note.json", ioutil.NopCloser(strings.NewReader(`{"a":1}`)))
+	ctx = HttpPost(ts.URL, form).
+		SetRetryFunc(func(ctx *Context) { retried = true }).
+		SetFailedFunc(func(ctx *Context) { failed = true })
+	ctx.Do()
+	if retried || !failed || ctx.Response.StatusCode != http.StatusServiceUnavailable || ctx.Request.ContentLength != -1 {
+		t.Fatalf("retried %v failed %v", retried, failed)
+	}
+	if ctx.resendable() {
+		t.Fatal("the form was sent")
+	}
+
+	// tasks with a multipart form can be stored in a queue
+	atomic.StoreInt32(&hits, 1)
+	task := &Task{Url: ts.URL, Method: "POST", Multipart: NewMultipartForm().AddField("name", "esme").AddFile("file", file)}
+	b, err := json.Marshal(task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	task = new(Task)
+	if err = json.Unmarshal(b, task); err != nil {
+		t.Fatal(err)
+	}
+	ctx = DoRequest(task.Url, task.Method, task.Multipart, task)
+	ctx.Do()
+	if !strings.HasPrefix(ctx.ToString(), "esme|a.txt|text/plain; charset=utf-8|hello|||") || strings.HasSuffix(ctx.ToString(), "|-1") {
+		t.Fatalf("got %s", ctx.ToString())
+	}
+}
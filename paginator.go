@@ -0,0 +1,234 @@
+/*
+paginator.go
+generate the task of the next page of a paginated response
+*/
+
+package esme
+
+import (
+	"net/http"
+	burl "net/url"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/gjson"
+	"github.com/zituocn/esme/logx"
+)
+
+const (
+
+	// paginatorSeenSize number of previous cursors and urls kept to detect loops
+	paginatorSeenSize = 32
+)
+
+// Paginator find the next page of a response
+//	attached to a Task, the Job adds the task of the next page to the queue
+//	after a successful response. the next page is found by, in order:
+//	CursorPath, LinkHeader, NextSelector and PageParam
+type Paginator struct {
+
+	// PageParam query parameter of the page number or offset,
+	//	a body other than json also needs ItemsPath, ItemsSelector or MaxPages
+	PageParam string `json:"page_param,omitempty"`
+
+	// Start value of PageParam on the first page
+	Start int `json:"start,omitempty"`
+
+	// Step increment of PageParam, 1 by default,
+	//	the page size for offsets
+	Step int `json:"step,omitempty"`
+
+	// CursorPath gjson path of the cursor of the next page in the response
+	CursorPath string `json:"cursor_path,omitempty"`
+
+	// CursorParam query parameter sending the cursor
+	CursorParam string `json:"cursor_param,omitempty"`
+
+	// LinkHeader follow the rel="next" url of the Link header
+	LinkHeader bool `json:"link_header,omitempty"`
+
+	// NextSelector css selector of the "next" anchor
+	NextSelector string `json:"next_selector,omitempty"`
+
+	// ItemsPath gjson path of the items of a page,
+	//	a page without items is the last page. without ItemsPath and ItemsSelector,
+	//	an empty json array, or an object whose arrays are all empty, is the last page
+	ItemsPath string `json:"items_path,omitempty"`
+
+	// ItemsSelector css selector of the items of a page,
+	//	a page without items is the last page
+	ItemsSelector string `json:"items_selector,omitempty"`
+
+	// MaxPages maximum number of pages, 0 means unlimited
+	MaxPages int `json:"max_pages,omitempty"`
+
+	// StopFunc returns true on the last page
+	StopFunc func(ctx *Context) bool `json:"-"`
+
+	// Page number of pages before the current one
+	Page int `json:"page"`
+
+	// Seen cursors and urls of the last 32 pages, a repeated one stops the pagination
+	Seen []string `json:"seen,omitempty"`
+}
+
+// Next returns the task of the next page of ctx, nil on the last page
+func (p *Paginator) Next(ctx *Context) *Task {
+	if ctx == nil || ctx.Task == nil || ctx.Request == nil {
+		return nil
+	}
+	if p.MaxPages > 0 && p.Page+1 >= p.MaxPages {
+		return nil
+	}
+	if p.empty(ctx) || (p.StopFunc != nil && p.StopFunc(ctx)) {
+		return nil
+	}
+
+	current := ctx.Request.URL
+	next := ""
+	key := ""
+	switch {
+	case p.CursorPath != "":
+		cursor := ctx.ToSection(p.CursorPath)
+		if cursor == "" || p.CursorParam == "" {
+			return nil
+		}
+		next = setQueryParam(current, p.CursorParam, cursor)
+		key = "cursor:" + cursor
+	case p.LinkHeader:
+		ref := parseLinkHeader(ctx.Response.Header)["next"]
+		if ref == "" {
+			return nil
+		}
+		next = ctx.HTML().AbsURL(ref)
+	case p.NextSelector != "":
+		doc := ctx.HTML()
+		next = doc.AttrURL(doc.Find(p.NextSelector), "href")
+	case p.PageParam != "":
+		// the last page of a body other than json can not be found without them
+		if p.ItemsPath == "" && p.ItemsSelector == "" && p.MaxPages <= 0 && !gjson.ValidBytes(ctx.RespBody) {
+			logx.Warnf("paginator: PageParam needs ItemsPath, ItemsSelector or MaxPages: %s", current.String())
+			return nil
+		}
+		step := p.Step
+		if step == 0 {
+			step = 1
+		}
+		next = setQueryParam(current, p.PageParam, strconv.Itoa(p.Start+(p.Page+1)*step))
+	}
+	if next == "" || next == current.String() {
+		return nil
+	}
+	if key == "" {
+		key = next
+	}
+
+	// a repeated cursor or url means a loop
+	for _, s := range p.Seen {
+		if s == key {
+			return nil
+		}
+	}
+
+	task := copyTask(ctx.Task)
+	task.Url = next
+	paginator := *p
+	paginator.Page++
+	// only the last pages are kept, copying all of them on every page is quadratic
+	seen := p.Seen
+	if len(seen) >= paginatorSeenSize {
+		seen = seen[len(seen)-paginatorSeenSize+1:]
+	}
+	paginator.Seen = append(append(make([]string, 0, len(seen)+1), seen...), key)
+	task.Paginator = &paginator
+	return task
+}
+
+/*
+private
+*/
+
+// empty returns whether the page has no items
+func (p *Paginator) empty(ctx *Context) bool {
+	if len(ctx.RespBody) == 0 {
+		return true
+	}
+	if p.ItemsPath != "" {
+		items := gjson.GetBytes(ctx.RespBody, p.ItemsPath)
+		if !items.Exists() || (items.IsArray() && len(items.Array()) == 0) {
+			return true
+		}
+	}
+	if p.ItemsSelector != "" && ctx.HTML().Find(p.ItemsSelector).Length() == 0 {
+		return true
+	}
+	if p.ItemsPath == "" && p.ItemsSelector == "" {
+		return emptyJSON(ctx.RespBody)
+	}
+	return false
+}
+
+// emptyJSON returns whether body is null, an empty json array,
+//	or an object without fields or whose array fields are all empty
+func emptyJSON(body []byte) bool {
+	if !gjson.ValidBytes(body) {
+		return false
+	}
+	result := gjson.ParseBytes(body)
+	switch {
+	case result.Type == gjson.Null:
+		return true
+	case result.IsArray():
+		return len(result.Array()) == 0
+	case result.IsObject():
+		fields, arrays := 0, 0
+		empty := true
+		result.ForEach(func(_, value gjson.Result) bool {
+			fields++
+			if value.IsArray() {
+				arrays++
+				empty = len(value.Array()) == 0
+			}
+			return empty
+		})
+		return fields == 0 || (arrays > 0 && empty)
+	}
+	return false
+}
+
+// setQueryParam returns u with the query parameter name set to value
+func setQueryParam(u *burl.URL, name, value string) string {
+	next := *u
+	values := next.Query()
+	values.Set(name, value)
+	next.RawQuery = values.Encode()
+	return next.String()
+}
+
+// parseLinkHeader returns the urls of the Link header by rel
+//	like: <https://api.example.com/items?page=2>; rel="next", <...>; rel="last"
+func parseLinkHeader(header http.Header) map[string]string {
+	links := make(map[string]string)
+	for _, line := range header.Values("Link") {
+		for _, item := range strings.Split(line, ",") {
+			parts := strings.Split(item, ";")
+			ref := strings.TrimSpace(parts[0])
+			if !strings.HasPrefix(ref, "<") || !strings.HasSuffix(ref, ">") {
+				continue
+			}
+			ref = ref[1 : len(ref)-1]
+			for _, param := range parts[1:] {
+				kvs := strings.SplitN(strings.TrimSpace(param), "=", 2)
+				if len(kvs) != 2 || strings.ToLower(kvs[0]) != "rel" {
+					continue
+				}
+				for _, rel := range strings.Fields(strings.Trim(kvs[1], `"`)) {
+					if _, ok := links[strings.ToLower(rel)]; !ok {
+						links[strings.ToLower(rel)] = ref
+					}
+				}
+			}
+		}
+	}
+	return links
+}
@@ -0,0 +1,122 @@
+package esme
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func Test_Paginator(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		switch r.URL.Path {
+		case "/page":
+			if page > 3 {
+				_, _ = fmt.Fprint(w, `{"items":[]}`)
+				return
+			}
+			_, _ = fmt.Fprintf(w, `{"items":[%d]}`, page)
+		case "/array":
+			if page > 2 {
+				_, _ = fmt.Fprint(w, `[]`)
+				return
+			}
+			_, _ = fmt.Fprintf(w, `[%d]`, page)
+		case "/cursor":
+			next := map[string]string{"": "a", "a": "b", "b": "c", "c": "a"}[r.URL.Query().Get("cursor")]
+			_, _ = fmt.Fprintf(w, `{"items":[1],"data":{"next":"%s"}}`, next)
+		case "/link":
+			if page < 3 {
+				w.Header().Set("Link", fmt.Sprintf(`</link?page=%d>; rel="next", </link?page=3>; rel="last"`, page+1))
+			}
+			_, _ = fmt.Fprint(w, "ok")
+		case "/html":
+			w.Header().Set("Content-Type", "text/html")
+			if page < 2 {
+				_, _ = fmt.Fprintf(w, `<ul><li>1</li></ul><a class="next" href="html?page=%d">next</a>`, page+1)
+				return
+			}
+			_, _ = fmt.Fprint(w, `<ul><li>1</li></ul>`)
+		}
+	}))
+	defer ts.Close()
+
+	cases := []struct {
+		path      string
+		paginator *Paginator
+		want      []string
+	}{
+		{"/page?page=1", &Paginator{PageParam: "page", Start: 1, ItemsPath: "items"},
+			[]string{"/page?page=1", "/page?page=2", "/page?page=3", "/page?page=4"}},
+		{"/page?page=1", &Paginator{PageParam: "page", Start: 1, ItemsPath: "items", MaxPages: 2},
+			[]string{"/page?page=1", "/page?page=2"}},
+		// without ItemsPath, an empty json array or an object with empty arrays is the last page
+		{"/page?page=1", &Paginator{PageParam: "page", Start: 1},
+			[]string{"/page?page=1", "/page?page=2", "/page?page=3", "/page?page=4"}},
+		{"/array?page=1", &Paginator{PageParam: "page", Start: 1},
+			[]string{"/array?page=1", "/array?page=2", "/array?page=3"}},
+		// a page number of html without ItemsSelector or MaxPages stops
+		{"/html?page=0", &Paginator{PageParam: "page"}, []string{"/html?page=0"}},
+		{"/html?page=0", &Paginator{PageParam: "page", MaxPages: 2}, []string{"/html?page=0", "/html?page=1"}},
+		{"/cursor", &Paginator{CursorPath: "data.next", CursorParam: "cursor"},
+			[]string{"/cursor", "/cursor?cursor=a", "/cursor?cursor=b", "/cursor?cursor=c"}},
+		{"/link?page=1", &Paginator{LinkHeader: true},
+			[]string{"/link?page=1", "/link?page=2", "/link?page=3"}},
+		{"/html?page=0", &Paginator{NextSelector: "a.next", ItemsSelector: "li"},
+			[]string{"/html?page=0", "/html?page=1", "/html?page=2"}},
+	}
+	for _, item := range cases {
+		var (
+			mux     sync.Mutex
+			visited []string
+		)
+		job := NewJob("paginator", 1, NewMemQueue(), JobOptions{
+			SucceedFunc: func(ctx *Context) {
+				mux.Lock()
+				visited = append(visited, strings.TrimPrefix(ctx.Request.URL.String(), ts.URL))
+				mux.Unlock()
+			},
+		})
+		job.queue.Add(&Task{Url: ts.URL + item.path, Method: "GET", Paginator: item.paginator})
+		job.Do()
+		if strings.Join(visited, " ") != strings.Join(item.want, " ") {
+			t.Fatalf("%s: got %v want %v", item.path, visited, item.want)
+		}
+	}
+
+	for body, want := range map[string]bool{
+		`[]`: true, `{}`: true, `null`: true, `{"total":0,"items":[],"tags":[]}`: true,
+		`{"total":0}`: false, `{"items":[],"tags":[1]}`: false, `[1]`: false, `<html></html>`: false,
+	} {
+		if emptyJSON([]byte(body)) != want {
+			t.Fatalf("%s: want %v", body, want)
+		}
+	}
+
+	links := parseLinkHeader(http.Header{"Link": {`<a>; rel="prev next", <b>; rel=last`}})
+	if links["next"] != "a" || links["prev"] != "a" || links["last"] != "b" {
+		t.Fatalf("got %v", links)
+	}
+}
+
+func Test_PaginatorSeen(t *testing.T) {
+	ctx := HttpGet("http://127.0.0.1/page?page=1")
+	ctx.Task = &Task{Url: ctx.Request.URL.String(), Method: "GET"}
+	ctx.RespBody = []byte(`{"items":[1]}`)
+
+	p := &Paginator{PageParam: "page", Start: 1}
+	for i := 0; i < 100; i++ {
+		task := p.Next(ctx)
+		if task == nil {
+			t.Fatalf("page %d: no next page", i)
+		}
+		p = task.Paginator
+	}
+	if p.Page != 100 || len(p.Seen) != paginatorSeenSize || p.Seen[len(p.Seen)-1] != "http://127.0.0.1/page?page=101" {
+		t.Fatalf("page %d seen %d %v", p.Page, len(p.Seen), p.Seen[len(p.Seen)-1])
+	}
+}
@@ -0,0 +1,73 @@
+/*
+query.go
+query parameters merged into the request url
+*/
+
+package esme
+
+import (
+	burl "net/url"
+)
+
+// Query query parameters of the request url
+//	values are escaped and replace the parameters of the same name in the url
+//	like: esme.HttpGet("https://example.com/search?page=1", esme.Query{"q": "成都"})
+type Query map[string]string
+
+func (q Query) Set(k, v string) Query {
+	q[k] = v
+	return q
+}
+
+// mergeQuery returns rawURL with the parameters of query
+//	url.Values keeps every value of a parameter
+func mergeQuery(rawURL string, query burl.Values) (string, error) {
+	if len(query) == 0 {
+		return rawURL, nil
+	}
+	u, err := burl.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	values := u.Query()
+	for k, vs := range query {
+		values[k] = vs
+	}
+	u.RawQuery = values.Encode()
+	return u.String(), nil
+}
+
+// withoutQuery returns rawURL without the query parameters names
+func withoutQuery(rawURL string, names []string) string {
+	if len(names) == 0 {
+		return rawURL
+	}
+	u, err := burl.Parse(rawURL)
+	if err != nil || u.RawQuery == "" {
+		return rawURL
+	}
+	values := u.Query()
+	for _, name := range names {
+		values.Del(name)
+	}
+	u.RawQuery = values.Encode()
+	return u.String()
+}
+
+// queryValues returns the query parameters in vs
+func queryValues(vs []interface{}) burl.Values {
+	values := burl.Values{}
+	for _, v := range vs {
+		switch vv := v.(type) {
+		case Query:
+			for k, v := range vv {
+				values.Set(k, v)
+			}
+		case burl.Values:
+			for k, v := range vv {
+				values[k] = v
+			}
+		}
+	}
+	return values
+}
@@ -0,0 +1,33 @@
+package esme
+
+import (
+	"net/http"
+	"net/http/httptest"
+	burl "net/url"
+	"testing"
+)
+
+func Test_Query(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.URL.RawQuery))
+	}))
+	defer ts.Close()
+
+	cases := []struct {
+		url  string
+		vs   []interface{}
+		want string
+	}{
+		{ts.URL + "/s", []interface{}{Query{"q": "成都 天气"}}, "q=%E6%88%90%E9%83%BD+%E5%A4%A9%E6%B0%94"},
+		{ts.URL + "/s?page=1&q=a", []interface{}{Query{"page": "2"}}, "page=2&q=a"},
+		{ts.URL + "/s", []interface{}{burl.Values{"id": {"1", "2"}}, Query{"a": "&"}}, "a=%26&id=1&id=2"},
+		{ts.URL + "/s?x=1", nil, "x=1"},
+	}
+	for _, item := range cases {
+		ctx := HttpGet(item.url, item.vs...)
+		ctx.Do()
+		if ctx.ToString() != item.want {
+			t.Fatalf("%s: got %s want %s", item.url, ctx.ToString(), item.want)
+		}
+	}
+}
@@ -100,11 +100,14 @@ func (q *RedisQueue) Clear() bool {
 }
 
 // IsEmpty returns whether the queue is empty
+//	false when redis returns an error, the tasks may still be there
 func (q *RedisQueue) IsEmpty() bool {
-	if q.Size() == 0 {
-		return true
+	i, err := q.rdb.LLen(ctx, q.key).Result()
+	if err != nil {
+		logx.Errorf("IsEmpty: %s", err.Error())
+		return false
 	}
-	return false
+	return i == 0
 }
 
 // Size returns queue length
@@ -0,0 +1,147 @@
+/*
+redirect.go
+redirect policy and redirect chain of a request
+*/
+
+package esme
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+const (
+
+	// defaultMaxRedirects redirects followed by default, like net/http
+	defaultMaxRedirects = 10
+)
+
+// RedirectPolicy how redirects are followed
+type RedirectPolicy struct {
+
+	// Max maximum number of redirects followed, 10 by default
+	Max int
+
+	// SameDomain refuse redirects to another domain (eTLD+1) than the first request
+	SameDomain bool
+
+	// NoFollow do not follow redirects,
+	//	the 3xx response is returned to the callbacks, read its Location header
+	NoFollow bool
+}
+
+// Redirect a redirect followed by the request
+type Redirect struct {
+
+	// URL url of the request answered with a redirect
+	URL *url.URL
+
+	// StatusCode status code of the redirect response
+	StatusCode int
+
+	// Location url the request was redirected to
+	Location *url.URL
+}
+
+// RedirectError a redirect refused by the RedirectPolicy or the CheckRedirect of the client
+type RedirectError struct {
+
+	// URL url of the refused redirect
+	URL *url.URL
+
+	// Err reason of the refusal
+	Err error
+}
+
+// Error returns the error message
+func (e *RedirectError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the reason of the refusal
+func (e *RedirectError) Unwrap() error {
+	return e.Err
+}
+
+// SetRedirectPolicy set how redirects are followed
+func (c *Context) SetRedirectPolicy(policy *RedirectPolicy) *Context {
+	if policy == nil {
+		return c
+	}
+	c.redirect = policy
+	return c
+}
+
+// RedirectChain returns the redirects followed by the last request, in order
+func (c *Context) RedirectChain() []*Redirect {
+	return c.redirects
+}
+
+/*
+private
+*/
+
+// checkRedirect returns the CheckRedirect of the client
+//	next is the CheckRedirect of the client set by the user
+func (c *Context) checkRedirect(next func(*http.Request, []*http.Request) error) func(*http.Request, []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		if p := c.redirect; p != nil {
+			if p.NoFollow {
+				return http.ErrUseLastResponse
+			}
+			if p.SameDomain && !sameDomain(via[0].URL, req.URL) {
+				return &RedirectError{URL: req.URL, Err: fmt.Errorf("redirect to another domain refused: %s", req.URL)}
+			}
+			max := p.Max
+			if max <= 0 {
+				max = defaultMaxRedirects
+			}
+			if len(via) > max {
+				return &RedirectError{URL: req.URL, Err: fmt.Errorf("stopped after %d redirects", max)}
+			}
+		} else if next != nil {
+			if err := next(req, via); err != nil {
+				if err == http.ErrUseLastResponse {
+					return err
+				}
+				return &RedirectError{URL: req.URL, Err: err}
+			}
+		} else if len(via) > defaultMaxRedirects {
+			return &RedirectError{URL: req.URL, Err: fmt.Errorf("stopped after %d redirects", defaultMaxRedirects)}
+		}
+
+		redirect := &Redirect{
+			URL:      via[len(via)-1].URL,
+			Location: req.URL,
+		}
+		if req.Response != nil {
+			redirect.StatusCode = req.Response.StatusCode
+		}
+		c.redirects = append(c.redirects, redirect)
+		return nil
+	}
+}
+
+// sameDomain returns whether a and b have the same registrable domain
+func sameDomain(a, b *url.URL) bool {
+	ha, hb := a.Hostname(), b.Hostname()
+	if ha == hb {
+		return true
+	}
+	if net.ParseIP(ha) != nil || net.ParseIP(hb) != nil {
+		return false
+	}
+	da, err := publicsuffix.EffectiveTLDPlusOne(ha)
+	if err != nil {
+		return false
+	}
+	db, err := publicsuffix.EffectiveTLDPlusOne(hb)
+	if err != nil {
+		return false
+	}
+	return da == db
+}
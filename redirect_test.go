@@ -0,0 +1,93 @@
+package esme
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func Test_RedirectPolicy(t *testing.T) {
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/a":
+			http.SetCookie(w, &http.Cookie{Name: "sid", Value: "1", Path: "/"})
+			http.Redirect(w, r, "/b", http.StatusFound)
+		case "/b":
+			http.Redirect(w, r, "/c", http.StatusMovedPermanently)
+		case "/c":
+			cookie, _ := r.Cookie("sid")
+			if cookie == nil {
+				_, _ = w.Write([]byte("no cookie"))
+				return
+			}
+			_, _ = w.Write([]byte("sid=" + cookie.Value))
+		case "/other":
+			http.Redirect(w, r, strings.Replace(ts.URL, "127.0.0.1", "localhost", 1)+"/c", http.StatusFound)
+		}
+	}))
+	defer ts.Close()
+
+	// follow with cookies set by the hops
+	ctx := HttpGet(ts.URL + "/a")
+	ctx.Do()
+	if ctx.ToString() != "sid=1" {
+		t.Fatalf("got %s", ctx.ToString())
+	}
+	chain := ctx.RedirectChain()
+	if len(chain) != 2 || chain[0].StatusCode != http.StatusFound || chain[0].Location.Path != "/b" ||
+		chain[1].URL.Path != "/b" || chain[1].StatusCode != http.StatusMovedPermanently || ctx.FinalURL().Path != "/c" {
+		t.Fatalf("chain %v", chain)
+	}
+
+	// no follow, the 3xx reaches the success callback
+	var location string
+	ctx = HttpGet(ts.URL + "/a").SetRedirectPolicy(&RedirectPolicy{NoFollow: true}).
+		SetSucceedFunc(func(ctx *Context) {
+			location = ctx.Response.Header.Get("Location")
+		})
+	ctx.Do()
+	if location != "/b" || len(ctx.RedirectChain()) != 0 {
+		t.Fatalf("location %s chain %v", location, ctx.RedirectChain())
+	}
+
+	ctx = HttpGet(ts.URL + "/a").SetRedirectPolicy(&RedirectPolicy{Max: 1})
+	ctx.Do()
+	if ctx.Err == nil || !strings.Contains(ctx.Err.Error(), "stopped after 1 redirects") {
+		t.Fatalf("err %v", ctx.Err)
+	}
+
+	ctx = HttpGet(ts.URL + "/other").SetRedirectPolicy(&RedirectPolicy{SameDomain: true})
+	ctx.Do()
+	var redirectErr *RedirectError
+	var reqErr *RequestError
+	if !errors.As(ctx.Err, &redirectErr) || !errors.As(ctx.Err, &reqErr) || reqErr.Kind != ErrorRedirect ||
+		!strings.Contains(ctx.Err.Error(), "another domain") {
+		t.Fatalf("err %v", ctx.Err)
+	}
+
+	// a dial error to a host named like a redirect is a connection error
+	dialErr := &url.Error{Op: "Get", URL: "http://redirect.example.com/", Err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}}
+	if kind := errorKind(dialErr); kind != ErrorConnection {
+		t.Fatalf("kind %s", kind)
+	}
+
+	for _, item := range []struct {
+		a, b string
+		want bool
+	}{
+		{"https://www.example.com", "https://img.example.com", true},
+		{"https://a.example.com", "https://example.org", false},
+		{"http://127.0.0.1", "http://localhost", false},
+	} {
+		a, _ := http.NewRequest("GET", item.a, nil)
+		b, _ := http.NewRequest("GET", item.b, nil)
+		if sameDomain(a.URL, b.URL) != item.want {
+			t.Fatalf("%s %s: want %v", item.a, item.b, item.want)
+		}
+	}
+}
@@ -24,6 +24,9 @@ import (
 const (
 	defaultContentType = "text/html; charset=utf-8"
 	defaultUserAgent   = "Go-http-client/esme/1.0"
+
+	// defaultMaxRetries max times a request is executed again after a retry status code
+	defaultMaxRetries = 3
 )
 
 // Header esme.Header
@@ -77,12 +80,13 @@ func HttpPut(url string, data []byte, vs ...interface{}) *Context {
 }
 
 // DoRequest start an http request
-//	returns esme.Context
+//	returns esme.Context, when the request can not be created
+//	the error is returned by ctx.Do() and goes to the callbacks
 func DoRequest(url, method string, vs ...interface{}) *Context {
 	ctx, err := NewRequest(url, method, vs...)
 	if err != nil {
 		logx.Errorf("DoRequest 错误 :%v", err.Error())
-		return nil
+		return errorContext(url, method, err, vs...)
 	}
 	return ctx
 }
@@ -101,7 +105,14 @@ func NewRequest(url, method string, vs ...interface{}) (*Context, error) {
 	if errU != nil {
 		return nil, errU
 	}
+	u, err := mergeQuery(u, queryValues(vs))
+	if err != nil {
+		return nil, err
+	}
 	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return nil, err
+	}
 	for _, v := range vs {
 		switch vv := v.(type) {
 		case FormData:
@@ -115,6 +126,24 @@ func NewRequest(url, method string, vs ...interface{}) (*Context, error) {
 					return nil, err
 				}
 			}
+		case *MultipartForm:
+			if vv != nil {
+				req, err = newMultipartRequest(method, u, vv)
+				if err != nil {
+					return nil, err
+				}
+			}
+		case *JSONBody:
+			if vv != nil {
+				body, err := vv.encode()
+				if err != nil {
+					return nil, err
+				}
+				req, err = http.NewRequest(method, u, bytes.NewReader(body))
+				if err != nil {
+					return nil, err
+				}
+			}
 		case []byte:
 			if len(vv) > 0 {
 				req, err = http.NewRequest(method, u, bytes.NewReader(vv))
@@ -184,6 +213,17 @@ func NewContext(req *http.Request, vs ...interface{}) *Context {
 			if len(vv) > 0 {
 				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 			}
+		case *MultipartForm:
+			if vv != nil {
+				req.Header.Set("Content-Type", vv.ContentType())
+			}
+		case *JSONBody:
+			if vv != nil {
+				req.Header.Set("Content-Type", jsonContentType)
+				if req.Header.Get("Accept") == "" {
+					req.Header.Set("Accept", "application/json")
+				}
+			}
 		}
 	}
 
@@ -199,7 +239,7 @@ func NewContext(req *http.Request, vs ...interface{}) *Context {
 		PublicSuffixList: publicsuffix.List,
 	}
 	jar, err := cookiejar.New(&options)
-	if err != nil {
+	if err == nil && client.Jar == nil {
 		client.Jar = jar
 	}
 
@@ -216,10 +256,11 @@ func NewContext(req *http.Request, vs ...interface{}) *Context {
 	}
 
 	return &Context{
-		client:  client,
-		Request: req,
-		Task:    task,
-		Data:    make(map[string]interface{}),
+		client:     client,
+		Request:    req,
+		Task:       task,
+		Data:       make(map[string]interface{}),
+		maxRetries: defaultMaxRetries,
 	}
 }
 
@@ -227,6 +268,26 @@ func NewContext(req *http.Request, vs ...interface{}) *Context {
 private
 */
 
+// errorContext returns a Context whose Do returns err
+func errorContext(rawURL, method string, err error, vs ...interface{}) *Context {
+	u, errU := burl.Parse(rawURL)
+	if errU != nil {
+		u = &burl.URL{}
+	}
+	req := &http.Request{
+		Method:     method,
+		URL:        u,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{},
+		Host:       u.Host,
+	}
+	ctx := NewContext(req, vs...)
+	ctx.buildErr = err
+	return ctx
+}
+
 func getDefaultClient() *http.Client {
 	return &http.Client{
 		Timeout: 30 * time.Second,
@@ -236,7 +297,7 @@ func getDefaultClient() *http.Client {
 func getDefaultTransport() *http.Transport {
 	return &http.Transport{
 		MaxIdleConns:    100,
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		TLSClientConfig: &tls.Config{},
 	}
 }
 
@@ -246,7 +307,7 @@ func validUrl(urlStr string) (string, error) {
 		return "", fmt.Errorf("error request url : %s", urlStr)
 	}
 
-	if urlStr[:7] == "http://" || urlStr[:8] == "https://" {
+	if strings.HasPrefix(urlStr, "http://") || strings.HasPrefix(urlStr, "https://") {
 		return urlStr, nil
 	}
 
@@ -6,11 +6,20 @@ import (
 )
 
 func Test_RequestAndResponse(t *testing.T) {
-	ctx := HttpGet("https://tenapi.cn/douyinresou/")
+	cassette, err := NewCassette("testdata/cassettes/douyinresou.json", CassetteReplay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	succeed := false
+	ctx := HttpGet("https://tenapi.cn/douyinresou/").SetCassette(cassette)
 	ctx.SetSucceedFunc(func(c *Context) {
+		succeed = true
 		fmt.Println("数据获取成功了...")
 	})
 	ctx.Do()
 	str := ctx.ToString()
 	fmt.Println(str)
+	if !succeed || ctx.ToSection("list.0.name") != "端午节假期出行" {
+		t.Fatalf("replay failed: %s", str)
+	}
 }
@@ -0,0 +1,313 @@
+/*
+robots.go
+robots.txt parsing and per host caching
+*/
+
+package esme
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zituocn/esme/logx"
+)
+
+// Robots parsed robots.txt
+type Robots struct {
+
+	// groups rules grouped by user-agent
+	groups []*robotsGroup
+
+	// Sitemaps sitemap urls declared in robots.txt
+	Sitemaps []string
+}
+
+type robotsGroup struct {
+	agents     []string
+	rules      []*robotsRule
+	crawlDelay time.Duration
+}
+
+type robotsRule struct {
+	allow   bool
+	pattern string
+}
+
+var (
+
+	// allowAllRobots used when robots.txt does not exist
+	allowAllRobots = &Robots{}
+
+	// disallowAllRobots used when robots.txt can not be fetched
+	disallowAllRobots = &Robots{
+		groups: []*robotsGroup{{agents: []string{"*"}, rules: []*robotsRule{{allow: false, pattern: "/"}}}},
+	}
+)
+
+// ParseRobots parse the content of robots.txt
+func ParseRobots(b []byte) *Robots {
+	r := &Robots{}
+	var (
+		group *robotsGroup
+
+		// inRules the current group already has rules,
+		// a following user-agent line starts a new group
+		inRules bool
+	)
+
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		kvs := strings.SplitN(line, ":", 2)
+		if len(kvs) != 2 {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(kvs[0]))
+		value := strings.TrimSpace(kvs[1])
+
+		switch key {
+		case "user-agent":
+			if group == nil || inRules {
+				group = &robotsGroup{}
+				r.groups = append(r.groups, group)
+				inRules = false
+			}
+			group.agents = append(group.agents, strings.ToLower(value))
+		case "allow", "disallow":
+			if group == nil {
+				continue
+			}
+			inRules = true
+			// an empty disallow allows everything
+			if value == "" {
+				continue
+			}
+			group.rules = append(group.rules, &robotsRule{allow: key == "allow", pattern: value})
+		case "crawl-delay":
+			if group == nil {
+				continue
+			}
+			inRules = true
+			if f, err := strconv.ParseFloat(value, 64); err == nil && f > 0 {
+				group.crawlDelay = time.Duration(f * float64(time.Second))
+			}
+		case "sitemap":
+			if value != "" {
+				r.Sitemaps = append(r.Sitemaps, value)
+			}
+		}
+	}
+	return r
+}
+
+// Allowed returns whether userAgent may fetch the url path
+//	path includes the query, like: /search?q=go
+func (r *Robots) Allowed(userAgent, path string) bool {
+	group := r.group(userAgent)
+	if group == nil {
+		return true
+	}
+	if path == "" {
+		path = "/"
+	}
+	if path == "/robots.txt" {
+		return true
+	}
+
+	// the longest matching pattern wins, allow wins on a tie
+	var (
+		matched *robotsRule
+		length  = -1
+	)
+	for _, rule := range group.rules {
+		if !matchRobotsPattern(rule.pattern, path) {
+			continue
+		}
+		if len(rule.pattern) > length || (len(rule.pattern) == length && rule.allow) {
+			matched = rule
+			length = len(rule.pattern)
+		}
+	}
+	return matched == nil || matched.allow
+}
+
+// CrawlDelay returns Crawl-delay of the group of userAgent
+func (r *Robots) CrawlDelay(userAgent string) time.Duration {
+	group := r.group(userAgent)
+	if group == nil {
+		return 0
+	}
+	return group.crawlDelay
+}
+
+// group returns the group with the most specific user-agent matching userAgent
+//	falls back to the * group
+func (r *Robots) group(userAgent string) *robotsGroup {
+	userAgent = strings.ToLower(userAgent)
+	var (
+		matched  *robotsGroup
+		fallback *robotsGroup
+		length   int
+	)
+	for _, group := range r.groups {
+		for _, agent := range group.agents {
+			if agent == "*" {
+				if fallback == nil {
+					fallback = group
+				}
+				continue
+			}
+			if strings.Contains(userAgent, agent) && len(agent) > length {
+				matched = group
+				length = len(agent)
+			}
+		}
+	}
+	if matched != nil {
+		return matched
+	}
+	return fallback
+}
+
+// matchRobotsPattern match path against a robots.txt pattern
+//	* matches any sequence, a trailing $ anchors the end
+func matchRobotsPattern(pattern, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	if anchored {
+		pattern = pattern[:len(pattern)-1]
+	}
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	rest := path[len(parts[0]):]
+	for i, part := range parts[1:] {
+		if i == len(parts)-2 && anchored {
+			return strings.HasSuffix(rest, part)
+		}
+		idx := strings.Index(rest, part)
+		if idx < 0 {
+			return false
+		}
+		rest = rest[idx+len(part):]
+	}
+	return !anchored || rest == ""
+}
+
+// RobotsCache fetch and cache robots.txt per host
+type RobotsCache struct {
+	mux   *sync.Mutex
+	items map[string]*robotsEntry
+
+	// client http client used to fetch robots.txt
+	client *http.Client
+
+	// ttl how long a robots.txt is cached
+	ttl time.Duration
+}
+
+type robotsEntry struct {
+	once    sync.Once
+	robots  *Robots
+	expires time.Time
+}
+
+// NewRobotsCache returns a *RobotsCache
+//	robots.txt is fetched again after ttl, 0 means never
+func NewRobotsCache(ttl time.Duration) *RobotsCache {
+	return &RobotsCache{
+		mux:    &sync.Mutex{},
+		items:  make(map[string]*robotsEntry),
+		client: getDefaultClient(),
+		ttl:    ttl,
+	}
+}
+
+// Get returns the robots.txt of the host of rawURL
+//	a missing robots.txt (4xx) allows everything,
+//	a failed fetch (5xx or network error) disallows everything
+func (c *RobotsCache) Get(rawURL string) *Robots {
+	return c.GetWith(rawURL, c.client, "")
+}
+
+// GetWith returns the robots.txt of the host of rawURL like Get,
+//	a robots.txt not cached yet is fetched with client and userAgent
+func (c *RobotsCache) GetWith(rawURL string, client *http.Client, userAgent string) *Robots {
+	if client == nil {
+		client = c.client
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return allowAllRobots
+	}
+	key := u.Scheme + "://" + u.Host
+
+	c.mux.Lock()
+	entry, ok := c.items[key]
+	if !ok || (c.ttl > 0 && !entry.expires.IsZero() && time.Now().After(entry.expires)) {
+		entry = &robotsEntry{}
+		c.items[key] = entry
+	}
+	c.mux.Unlock()
+
+	entry.once.Do(func() {
+		entry.robots = fetchRobots(client, userAgent, key+"/robots.txt")
+		if c.ttl > 0 {
+			// expires is read under c.mux by other goroutines
+			c.mux.Lock()
+			entry.expires = time.Now().Add(c.ttl)
+			c.mux.Unlock()
+		}
+	})
+	return entry.robots
+}
+
+// Allowed returns whether userAgent may fetch rawURL
+func (c *RobotsCache) Allowed(userAgent, rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return true
+	}
+	return c.Get(rawURL).Allowed(userAgent, u.RequestURI())
+}
+
+// fetchRobots fetch and parse a robots.txt
+func fetchRobots(client *http.Client, userAgent, robotsURL string) *Robots {
+	req, err := http.NewRequest("GET", robotsURL, nil)
+	if err != nil {
+		return disallowAllRobots
+	}
+	if userAgent != "" {
+		req.Header.Set("User-Agent", userAgent)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		logx.Warnf("fetch robots.txt error: %s", err.Error())
+		return disallowAllRobots
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return disallowAllRobots
+		}
+		return ParseRobots(body)
+	case resp.StatusCode >= 400 && resp.StatusCode < 500:
+		return allowAllRobots
+	default:
+		logx.Warnf("fetch robots.txt %s : %d", robotsURL, resp.StatusCode)
+		return disallowAllRobots
+	}
+}
@@ -0,0 +1,126 @@
+package esme
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testRobots = `
+# comment
+User-agent: esmebot
+User-agent: otherbot
+Disallow: /private
+Allow: /private/open$
+Crawl-delay: 0.2
+
+User-agent: *
+Disallow: /
+Allow: /public/*.html
+
+Sitemap: https://www.example.com/sitemap.xml
+`
+
+func Test_ParseRobots(t *testing.T) {
+	r := ParseRobots([]byte(testRobots))
+	cases := []struct {
+		agent, path string
+		want        bool
+	}{
+		{"Mozilla/5.0 (compatible; EsmeBot/1.0)", "/index.html", true},
+		{"esmebot", "/private/data", false},
+		{"esmebot", "/private/open", true},
+		{"esmebot", "/private/open/x", false},
+		{"googlebot", "/index.html", false},
+		{"googlebot", "/public/a/b.html", true},
+		{"googlebot", "/public/a/b.htm", false},
+		{"googlebot", "/robots.txt", true},
+	}
+	for _, item := range cases {
+		if got := r.Allowed(item.agent, item.path); got != item.want {
+			t.Errorf("%s %s: got %v want %v", item.agent, item.path, got, item.want)
+		}
+	}
+	if d := r.CrawlDelay("esmebot"); d != 200*time.Millisecond {
+		t.Errorf("crawl-delay: got %v", d)
+	}
+	if len(r.Sitemaps) != 1 {
+		t.Errorf("sitemaps: got %v", r.Sitemaps)
+	}
+}
+
+func Test_JobRespectRobots(t *testing.T) {
+	var robotsFetched int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			atomic.AddInt32(&robotsFetched, 1)
+			_, _ = fmt.Fprint(w, testRobots)
+			return
+		}
+		_, _ = fmt.Fprint(w, "ok")
+	}))
+	defer ts.Close()
+
+	queue := NewMemQueue()
+	for _, path := range []string{"/a", "/private/x", "/b", "/private/open"} {
+		queue.Add(&Task{Url: ts.URL + path, Method: "GET"})
+	}
+	job := NewJob("robots", 2, queue, JobOptions{
+		RespectRobots:   true,
+		RobotsUserAgent: "esmebot",
+	})
+
+	start := time.Now()
+	job.Do()
+	elapsed := time.Since(start)
+
+	stats := job.Stats()
+	if stats.Disallowed != 1 || stats.Succeed != 3 || stats.Total != 3 {
+		t.Fatalf("stats: got %s", stats)
+	}
+	if robotsFetched != 1 {
+		t.Fatalf("robots.txt fetched %d times", robotsFetched)
+	}
+	// three requests to the same host with a crawl-delay of 200ms
+	if elapsed < 400*time.Millisecond {
+		t.Fatalf("crawl-delay not applied: %v", elapsed)
+	}
+}
+
+func Test_JobRobotsUserAgent(t *testing.T) {
+	var agents []string
+	var robotsAgent string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			robotsAgent = r.UserAgent()
+			_, _ = fmt.Fprint(w, testRobots)
+			return
+		}
+		agents = append(agents, r.UserAgent())
+		_, _ = fmt.Fprint(w, "ok")
+	}))
+	defer ts.Close()
+
+	// robots.txt is matched against the user-agent chosen by the policy,
+	//	and fetched with it through the transport of the job (its resolver here)
+	_, port, _ := net.SplitHostPort(strings.TrimPrefix(ts.URL, "http://"))
+	host := "robots.example.test:" + port
+	queue := NewMemQueue()
+	queue.Add(&Task{Url: "http://" + host + "/a", Method: "GET"})
+	queue.Add(&Task{Url: "http://" + host + "/private/x", Method: "GET"})
+	job := NewJob("robots-ua", 1, queue, JobOptions{
+		RespectRobots: true,
+		UserAgent:     &UserAgentPolicy{Mode: UAFixed, UserAgents: []string{"otherbot/2.0"}},
+		Resolver:      &Resolver{Hosts: map[string]string{host: "127.0.0.1"}},
+	})
+	job.Do()
+	if stats := job.Stats(); stats.Succeed != 1 || stats.Disallowed != 1 || len(agents) != 1 || agents[0] != "otherbot/2.0" ||
+		robotsAgent != "otherbot/2.0" {
+		t.Fatalf("stats %s agents %v robots %s", stats, agents, robotsAgent)
+	}
+}
@@ -0,0 +1,44 @@
+/*
+scheduler.go
+per host request scheduling
+*/
+
+package esme
+
+import (
+	"sync"
+	"time"
+)
+
+// hostScheduler keep requests to the same host apart
+type hostScheduler struct {
+	mux *sync.Mutex
+
+	// next the earliest time of the next request per host
+	next map[string]time.Time
+}
+
+func newHostScheduler() *hostScheduler {
+	return &hostScheduler{
+		mux:  &sync.Mutex{},
+		next: make(map[string]time.Time),
+	}
+}
+
+// wait block until a request to host is allowed
+//	requests to the same host are at least delay apart
+func (s *hostScheduler) wait(host string, delay time.Duration) {
+	if delay <= 0 || host == "" {
+		return
+	}
+	s.mux.Lock()
+	now := time.Now()
+	t := s.next[host]
+	if t.Before(now) {
+		t = now
+	}
+	s.next[host] = t.Add(delay)
+	s.mux.Unlock()
+
+	time.Sleep(t.Sub(now))
+}
@@ -0,0 +1,338 @@
+/*
+signer.go
+request signing before every attempt: sorted params with timestamp, nonce and a digest
+*/
+
+package esme
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Signer sign a request
+//	Sign runs just before the request is sent, on every attempt,
+//	so timestamps and nonces are new for every retry
+type Signer interface {
+	Sign(ctx *Context) error
+}
+
+// SignerFunc a function as Signer
+type SignerFunc func(ctx *Context) error
+
+// Sign call f
+func (f SignerFunc) Sign(ctx *Context) error {
+	return f(ctx)
+}
+
+// SignAlgorithm digest of a ParamSigner
+type SignAlgorithm string
+
+const (
+
+	// SignMD5 md5 of the params followed by the secret
+	SignMD5 SignAlgorithm = "md5"
+
+	// SignSHA256 sha256 of the params followed by the secret
+	SignSHA256 SignAlgorithm = "sha256"
+
+	// SignHMACSHA256 hmac-sha256 of the params keyed by the secret
+	SignHMACSHA256 SignAlgorithm = "hmac-sha256"
+
+	// SignHMACMD5 hmac-md5 of the params keyed by the secret
+	SignHMACMD5 SignAlgorithm = "hmac-md5"
+)
+
+// ParamSigner sign the params of the query, the form or the json object of the body
+//	a timestamp, a nonce and Params are added, then all the params except the signature
+//	are sorted by name and joined like "a=1&b=2". MD5 and SHA256 append "&key=<Secret>",
+//	HMAC uses the Secret as key. The signature is added to the body for forms and json,
+//	to the query otherwise
+type ParamSigner struct {
+
+	// Secret secret of the signature
+	Secret string
+
+	// Algorithm digest of the signature, SignMD5 by default
+	Algorithm SignAlgorithm
+
+	// SignParam name of the signature, "sign" by default
+	SignParam string
+
+	// TimestampParam name of the timestamp, "timestamp" by default, "-" to skip it
+	TimestampParam string
+
+	// NonceParam name of the nonce, "nonce" by default, "-" to skip it
+	NonceParam string
+
+	// Millis timestamp in milliseconds instead of seconds
+	Millis bool
+
+	// Upper signature in upper case hex
+	Upper bool
+
+	// SkipEmpty params with an empty value are not signed
+	SkipEmpty bool
+
+	// Params params added to every request, like app_key
+	Params map[string]string
+
+	// StringToSign build the signed string from the sorted params, replaces the default
+	StringToSign func(keys []string, params map[string]string, secret string) string
+}
+
+// NewMD5Signer returns a *ParamSigner of md5
+func NewMD5Signer(secret string) *ParamSigner {
+	return &ParamSigner{Secret: secret, Algorithm: SignMD5}
+}
+
+// NewSHA256Signer returns a *ParamSigner of sha256
+func NewSHA256Signer(secret string) *ParamSigner {
+	return &ParamSigner{Secret: secret, Algorithm: SignSHA256}
+}
+
+// NewHMACSigner returns a *ParamSigner of hmac-sha256
+func NewHMACSigner(secret string) *ParamSigner {
+	return &ParamSigner{Secret: secret, Algorithm: SignHMACSHA256}
+}
+
+// Sign add the timestamp, the nonce and the signature to the request
+func (s *ParamSigner) Sign(ctx *Context) error {
+	req := ctx.Request
+	query := req.URL.Query()
+
+	// params of the body
+	var (
+		form   url.Values
+		object map[string]json.RawMessage
+	)
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if mediaType == "application/x-www-form-urlencoded" || mediaType == "application/json" {
+		body, err := readRequestBody(req)
+		if err != nil {
+			return err
+		}
+		if mediaType == "application/x-www-form-urlencoded" {
+			if form, err = url.ParseQuery(string(body)); err != nil {
+				return err
+			}
+		} else if len(bytes.TrimSpace(body)) > 0 {
+			if err = json.Unmarshal(body, &object); err != nil {
+				return fmt.Errorf("json body is not an object: %s", err.Error())
+			}
+		}
+	}
+
+	added := make(map[string]string)
+	for k, v := range s.Params {
+		added[k] = v
+	}
+	if name := s.paramName(s.TimestampParam, "timestamp"); name != "" {
+		if s.Millis {
+			added[name] = strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10)
+		} else {
+			added[name] = strconv.FormatInt(time.Now().Unix(), 10)
+		}
+	}
+	if name := s.paramName(s.NonceParam, "nonce"); name != "" {
+		nonce, err := newNonce()
+		if err != nil {
+			return err
+		}
+		added[name] = nonce
+	}
+
+	// all the params, the added ones replace those of the request
+	params := make(map[string]string)
+	for k := range query {
+		params[k] = query.Get(k)
+	}
+	for k := range form {
+		params[k] = form.Get(k)
+	}
+	for k, v := range object {
+		params[k] = jsonParam(v)
+	}
+	for k, v := range added {
+		params[k] = v
+	}
+	signParam := s.paramName(s.SignParam, "sign")
+	delete(params, signParam)
+
+	keys := make([]string, 0, len(params))
+	for k, v := range params {
+		if s.SkipEmpty && v == "" {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	sign, err := s.digest(s.stringToSign(keys, params))
+	if err != nil {
+		return err
+	}
+	added[signParam] = sign
+
+	// write the added params where the request has its params
+	switch {
+	case form != nil:
+		for k, v := range added {
+			form.Set(k, v)
+		}
+		setRequestBody(req, []byte(form.Encode()))
+	case object != nil:
+		for k, v := range added {
+			b, _ := json.Marshal(v)
+			object[k] = b
+		}
+		b, err := json.Marshal(object)
+		if err != nil {
+			return err
+		}
+		setRequestBody(req, b)
+	default:
+		for k, v := range added {
+			query.Set(k, v)
+		}
+		req.URL.RawQuery = query.Encode()
+	}
+	return nil
+}
+
+// VaryingParams returns the names of the params changing on every attempt,
+//	the timestamp, the nonce and the signature.
+//	use them as IgnoreQuery of the cache and the cassette
+func (s *ParamSigner) VaryingParams() []string {
+	names := make([]string, 0, 3)
+	for _, name := range []string{
+		s.paramName(s.TimestampParam, "timestamp"),
+		s.paramName(s.NonceParam, "nonce"),
+		s.paramName(s.SignParam, "sign"),
+	} {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// SetSigner set the signer of the request, it runs before every attempt
+func (c *Context) SetSigner(signer Signer) *Context {
+	if signer == nil {
+		return c
+	}
+	c.signer = signer
+	return c
+}
+
+/*
+private
+*/
+
+// paramName returns name, def when it is empty and "" when it is "-"
+func (s *ParamSigner) paramName(name, def string) string {
+	switch name {
+	case "":
+		return def
+	case "-":
+		return ""
+	}
+	return name
+}
+
+// stringToSign returns the signed string of the sorted keys
+func (s *ParamSigner) stringToSign(keys []string, params map[string]string) string {
+	if s.StringToSign != nil {
+		return s.StringToSign(keys, params, s.Secret)
+	}
+	items := make([]string, 0, len(keys)+1)
+	for _, k := range keys {
+		items = append(items, k+"="+params[k])
+	}
+	switch s.Algorithm {
+	case SignHMACSHA256, SignHMACMD5:
+	default:
+		items = append(items, "key="+s.Secret)
+	}
+	return strings.Join(items, "&")
+}
+
+// digest returns the hex digest of str
+func (s *ParamSigner) digest(str string) (string, error) {
+	var h hash.Hash
+	switch s.Algorithm {
+	case "", SignMD5:
+		h = md5.New()
+	case SignSHA256:
+		h = sha256.New()
+	case SignHMACSHA256:
+		h = hmac.New(sha256.New, []byte(s.Secret))
+	case SignHMACMD5:
+		h = hmac.New(md5.New, []byte(s.Secret))
+	default:
+		return "", fmt.Errorf("unknown sign algorithm: %s", s.Algorithm)
+	}
+	_, _ = io.WriteString(h, str)
+	sign := hex.EncodeToString(h.Sum(nil))
+	if s.Upper {
+		sign = strings.ToUpper(sign)
+	}
+	return sign, nil
+}
+
+// jsonParam returns a json value as a param: strings unquoted, others as compact json
+func jsonParam(v json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(v, &s); err == nil {
+		return s
+	}
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, v); err != nil {
+		return string(v)
+	}
+	return buf.String()
+}
+
+// newNonce returns 16 random hex chars
+func newNonce() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// setRequestBody replace the body of req, every attempt sends a copy of body
+func setRequestBody(req *http.Request, body []byte) {
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+	req.ContentLength = int64(len(body))
+}
+
+// signHandler sign the request before next
+func signHandler(signer Signer, next Handler) Handler {
+	return func(c *Context) error {
+		if err := signer.Sign(c); err != nil {
+			return newRequestError(ErrorRequest, fmt.Errorf("sign request: %s", err.Error()))
+		}
+		return next(c)
+	}
+}
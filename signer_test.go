@@ -0,0 +1,179 @@
+package esme
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// verifySign returns whether the params carry a valid md5 or hmac-sha256 signature
+func verifySign(params map[string]string, secret string, useHMAC bool) bool {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		if k != "sign" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	items := make([]string, 0, len(keys))
+	for _, k := range keys {
+		items = append(items, k+"="+params[k])
+	}
+	var sum []byte
+	if useHMAC {
+		h := hmac.New(sha256.New, []byte(secret))
+		h.Write([]byte(strings.Join(items, "&")))
+		sum = h.Sum(nil)
+	} else {
+		s := md5.Sum([]byte(strings.Join(append(items, "key="+secret), "&")))
+		sum = s[:]
+	}
+	return params["timestamp"] != "" && params["nonce"] != "" && hex.EncodeToString(sum) == params["sign"]
+}
+
+func Test_ParamSigner(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params := map[string]string{}
+		for k := range r.URL.Query() {
+			params[k] = r.URL.Query().Get(k)
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		switch r.Header.Get("Content-Type") {
+		case "application/x-www-form-urlencoded":
+			form, _ := url.ParseQuery(string(body))
+			for k := range form {
+				params[k] = form.Get(k)
+			}
+		case jsonContentType:
+			var object map[string]json.RawMessage
+			_ = json.Unmarshal(body, &object)
+			for k, v := range object {
+				params[k] = jsonParam(v)
+			}
+		}
+		if !verifySign(params, "s3cret", r.URL.Path == "/hmac") || params["app_key"] != "esme" {
+			w.WriteHeader(http.StatusForbidden)
+		}
+		_, _ = w.Write([]byte(params["sign"]))
+	}))
+	defer ts.Close()
+
+	signer := NewMD5Signer("s3cret")
+	signer.Params = map[string]string{"app_key": "esme"}
+
+	ctx := HttpGet(ts.URL+"/md5", Query{"city": "成都", "page": "1"}).SetSigner(signer)
+	if err := ctx.Do(); err != nil || ctx.Response.StatusCode != http.StatusOK {
+		t.Fatalf("query: err %v body %s", err, ctx.ToString())
+	}
+	ctx = HttpPost(ts.URL+"/md5?page=2", FormData{"name": "esme"}).SetSigner(signer)
+	if err := ctx.Do(); err != nil || ctx.Response.StatusCode != http.StatusOK || ctx.Request.URL.Query().Get("sign") != "" {
+		t.Fatalf("form: err %v body %s", err, ctx.ToString())
+	}
+	ctx = HttpPost(ts.URL+"/md5", JSON(map[string]interface{}{"id": 7, "tags": []string{"a"}})).SetSigner(signer)
+	if err := ctx.Do(); err != nil || ctx.Response.StatusCode != http.StatusOK {
+		t.Fatalf("json: err %v body %s", err, ctx.ToString())
+	}
+
+	hmacSigner := NewHMACSigner("s3cret")
+	hmacSigner.Params = map[string]string{"app_key": "esme"}
+	ctx = HttpGet(ts.URL + "/hmac").SetSigner(hmacSigner)
+	if err := ctx.Do(); err != nil || ctx.Response.StatusCode != http.StatusOK {
+		t.Fatalf("hmac: err %v body %s", err, ctx.ToString())
+	}
+
+	err := HttpGet(ts.URL).SetSigner(&ParamSigner{Algorithm: "crc32"}).Do()
+	if err == nil || !strings.Contains(err.Error(), "unknown sign algorithm") {
+		t.Fatalf("err %v", err)
+	}
+}
+
+func Test_SignerRetry(t *testing.T) {
+	var (
+		mux    sync.Mutex
+		nonces []string
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		mux.Lock()
+		nonces = append(nonces, r.PostForm.Get("nonce"))
+		n := len(nonces)
+		mux.Unlock()
+		if n < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+
+	queue := NewMemQueue()
+	queue.Add(&Task{Url: ts.URL, Method: "POST", FormData: FormData{"a": "1"}})
+	job := NewJob("sign", 1, queue, JobOptions{
+		Signer: NewMD5Signer("s3cret"),
+
+		// 503 responses are retried
+		RetryFunc: func(ctx *Context) {},
+	})
+	job.Do()
+	if len(nonces) != 3 || nonces[0] == nonces[1] || nonces[1] == nonces[2] || nonces[0] == "" {
+		t.Fatalf("nonces %v", nonces)
+	}
+}
+
+func Test_SignerCacheAndCassette(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = fmt.Fprintf(w, "page=%s", r.URL.Query().Get("page"))
+	}))
+	signer := NewMD5Signer("s3cret")
+
+	// the timestamp, the nonce and the signature are left out of the cache key
+	cache := NewMemCache()
+	for i := 0; i < 2; i++ {
+		ctx := HttpGet(ts.URL, Query{"page": "1"}).SetSigner(signer).
+			SetCache(cache, CacheDefault).SetCacheIgnoreQuery(signer.VaryingParams()...)
+		ctx.Do()
+		if ctx.ToString() != "page=1" || ctx.FromCache() != (i == 1) {
+			t.Fatalf("%d: got %s from cache %v", i, ctx.ToString(), ctx.FromCache())
+		}
+	}
+	if n := atomic.LoadInt32(&hits); n != 1 {
+		t.Fatalf("hits %d", n)
+	}
+
+	// and out of the urls compared by the cassette
+	filename := filepath.Join(t.TempDir(), "cassette.json")
+	cassette, err := NewCassette(filename, CassetteRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue := NewMemQueue()
+	queue.Add(&Task{Url: ts.URL + "?page=1", Method: "GET"})
+	queue.Add(&Task{Url: ts.URL + "?page=2", Method: "GET"})
+	NewJob("sign-record", 1, queue, JobOptions{Signer: signer, Cassette: cassette}).Do()
+	ts.Close()
+
+	cassette, err = NewCassette(filename, CassetteReplay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cassette.Matcher.IgnoreQuery = signer.VaryingParams()
+	ctx := HttpGet(ts.URL, Query{"page": "2"}).SetSigner(signer).SetCassette(cassette)
+	ctx.Do()
+	if ctx.Err != nil || ctx.ToString() != "page=2" {
+		t.Fatalf("replay: got %q %v", ctx.ToString(), ctx.Err)
+	}
+}
@@ -0,0 +1,477 @@
+/*
+sitemap.go
+seed tasks from sitemap.xml, sitemap index, rss and atom feeds
+*/
+
+package esme
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zituocn/esme/logx"
+)
+
+const (
+
+	// maxSitemapDepth max nesting level of sitemap indexes
+	maxSitemapDepth = 5
+
+	// maxSitemapSize max size of a sitemap, compressed or not, 50MB like the sitemap protocol
+	maxSitemapSize = 50 << 20
+)
+
+var (
+
+	// lastmodLayouts W3C datetime used by sitemaps and atom,
+	//	and RFC 822 dates used by rss
+	lastmodLayouts = []string{
+		time.RFC3339Nano,
+		time.RFC3339,
+		"2006-01-02T15:04Z07:00",
+		"2006-01-02T15:04:05",
+		"2006-01-02",
+		time.RFC1123Z,
+		time.RFC1123,
+		time.RFC822Z,
+		time.RFC822,
+		"Mon, 2 Jan 2006 15:04:05 -0700",
+		"Mon, 2 Jan 2006 15:04:05 MST",
+	}
+)
+
+// SitemapEntry a page url found in a sitemap or feed
+type SitemapEntry struct {
+
+	// Loc page url
+	Loc string `json:"loc"`
+
+	// LastMod last modification time, zero when unknown
+	LastMod time.Time `json:"lastmod"`
+}
+
+// Seeder discover sitemaps and feeds and add their pages to a TodoQueue
+type Seeder struct {
+	queue TodoQueue
+
+	client *http.Client
+
+	// Since only pages modified after Since are added
+	//	zero means all pages
+	Since time.Time
+
+	// State lastmod of the pages crawled in previous runs
+	//	when set only new and changed pages are added,
+	//	a page is recorded by State.Done once it was crawled
+	State *SeedState
+
+	// Method method of the generated tasks, default GET
+	Method string
+
+	// Header header of the generated tasks
+	Header *http.Header
+}
+
+// NewSeeder returns a *Seeder adding tasks to queue
+func NewSeeder(queue TodoQueue) *Seeder {
+	return &Seeder{
+		queue:  queue,
+		client: getDefaultClient(),
+		Method: "GET",
+	}
+}
+
+// SeedSite discover the sitemaps of a site from robots.txt and add their pages
+//	falls back to /sitemap.xml when robots.txt declares none
+//	returns the number of tasks added
+func (s *Seeder) SeedSite(siteURL string) (int, error) {
+	siteURL, err := validUrl(siteURL)
+	if err != nil {
+		return 0, err
+	}
+	u, err := url.Parse(siteURL)
+	if err != nil {
+		return 0, err
+	}
+	root := u.Scheme + "://" + u.Host
+
+	sitemaps := make([]string, 0)
+	body, err := s.fetch(root + "/robots.txt")
+	if err == nil {
+		sitemaps = append(sitemaps, ParseRobots(body).Sitemaps...)
+	}
+	if len(sitemaps) == 0 {
+		sitemaps = append(sitemaps, root+"/sitemap.xml")
+	}
+	return s.Seed(sitemaps...)
+}
+
+// Seed add the pages of sitemaps, sitemap indexes or feeds
+//	returns the number of tasks added
+func (s *Seeder) Seed(urls ...string) (int, error) {
+	n := 0
+	var lastErr error
+	for _, item := range urls {
+		entries, err := s.Entries(item)
+		if err != nil {
+			logx.Errorf("seed %s error: %s", item, err.Error())
+			lastErr = err
+			continue
+		}
+		for _, entry := range entries {
+			if !s.accept(entry) {
+				continue
+			}
+			if s.State != nil {
+				s.State.add(entry)
+			}
+			s.queue.Add(&Task{
+				Url:    entry.Loc,
+				Method: s.Method,
+				Header: s.Header,
+			})
+			n++
+		}
+	}
+	return n, lastErr
+}
+
+// Entries returns the pages of a sitemap, sitemap index or feed
+//	nested sitemap indexes are walked, .gz content is decompressed
+func (s *Seeder) Entries(sitemapURL string) ([]*SitemapEntry, error) {
+	entries := make([]*SitemapEntry, 0)
+	err := s.walk(sitemapURL, 0, make(map[string]bool), &entries)
+	return entries, err
+}
+
+func (s *Seeder) walk(sitemapURL string, depth int, visited map[string]bool, entries *[]*SitemapEntry) error {
+	if visited[sitemapURL] {
+		return nil
+	}
+	visited[sitemapURL] = true
+
+	body, err := s.fetch(sitemapURL)
+	if err != nil {
+		return err
+	}
+	pages, sitemaps, err := ParseSitemap(body)
+	if err != nil {
+		return fmt.Errorf("parse %s : %s", sitemapURL, err.Error())
+	}
+	*entries = append(*entries, pages...)
+
+	if depth >= maxSitemapDepth {
+		return nil
+	}
+	for _, item := range sitemaps {
+		// a nested sitemap without lastmod may always contain new pages,
+		//	State is not checked: pages of an unchanged sitemap may not have been crawled
+		if !item.LastMod.IsZero() && !s.since(item) {
+			continue
+		}
+		if err = s.walk(item.Loc, depth+1, visited, entries); err != nil {
+			logx.Errorf("walk sitemap %s error: %s", item.Loc, err.Error())
+		}
+	}
+	return nil
+}
+
+// accept returns whether the entry is modified after Since and the last run
+func (s *Seeder) accept(entry *SitemapEntry) bool {
+	if !s.since(entry) {
+		return false
+	}
+	if s.State != nil {
+		return s.State.changed(entry)
+	}
+	return true
+}
+
+// since returns whether the entry is modified after Since
+func (s *Seeder) since(entry *SitemapEntry) bool {
+	return s.Since.IsZero() || entry.LastMod.IsZero() || entry.LastMod.After(s.Since)
+}
+
+// fetch returns the body of rawURL, gzip content is decompressed
+func (s *Seeder) fetch(rawURL string) ([]byte, error) {
+	req, err := http.NewRequest("GET", rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", defaultUserAgent)
+	if s.Header != nil && s.Header.Get("User-Agent") != "" {
+		req.Header.Set("User-Agent", s.Header.Get("User-Agent"))
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("fetch %s : %d", rawURL, resp.StatusCode)
+	}
+	body, err := readSitemap(resp.Body, rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	// sitemap.xml.gz, the transport only decompresses gzip it asked for itself
+	if len(body) > 2 && body[0] == 0x1f && body[1] == 0x8b {
+		r, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return readSitemap(r, rawURL)
+	}
+	return body, nil
+}
+
+// readSitemap returns the content of r, an error when it is larger than maxSitemapSize
+func readSitemap(r io.Reader, rawURL string) ([]byte, error) {
+	body, err := ioutil.ReadAll(io.LimitReader(r, maxSitemapSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxSitemapSize {
+		return nil, fmt.Errorf("sitemap %s exceeds %d bytes", rawURL, maxSitemapSize)
+	}
+	return body, nil
+}
+
+// ParseSitemap parse a sitemap, sitemap index, rss or atom feed
+//	returns the page urls and the nested sitemap urls
+//	a plain text sitemap has one url per line
+func ParseSitemap(b []byte) (pages []*SitemapEntry, sitemaps []*SitemapEntry, err error) {
+	pages = make([]*SitemapEntry, 0)
+	sitemaps = make([]*SitemapEntry, 0)
+
+	trimmed := bytes.TrimSpace(b)
+	if !bytes.HasPrefix(trimmed, []byte("<")) {
+		scanner := bufio.NewScanner(bytes.NewReader(trimmed))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if strings.HasPrefix(line, "http://") || strings.HasPrefix(line, "https://") {
+				pages = append(pages, &SitemapEntry{Loc: line})
+			}
+		}
+		return pages, sitemaps, nil
+	}
+
+	var doc sitemapDocument
+	decoder := xml.NewDecoder(bytes.NewReader(b))
+	decoder.Strict = false
+	decoder.CharsetReader = func(label string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+	if err = decoder.Decode(&doc); err != nil {
+		return nil, nil, err
+	}
+
+	switch doc.XMLName.Local {
+	case "urlset":
+		for _, item := range doc.URLs {
+			pages = appendEntry(pages, item.Loc, item.LastMod)
+		}
+	case "sitemapindex":
+		for _, item := range doc.Sitemaps {
+			sitemaps = appendEntry(sitemaps, item.Loc, item.LastMod)
+		}
+	case "rss", "RDF":
+		items := doc.Items
+		if doc.Channel != nil {
+			items = append(items, doc.Channel.Items...)
+		}
+		for _, item := range items {
+			lastmod := item.PubDate
+			if lastmod == "" {
+				lastmod = item.Date
+			}
+			loc := item.Link
+			if loc == "" && item.GUID.IsPermaLink != "false" {
+				loc = item.GUID.Value
+			}
+			pages = appendEntry(pages, loc, lastmod)
+		}
+	case "feed":
+		for _, item := range doc.Entries {
+			lastmod := item.Updated
+			if lastmod == "" {
+				lastmod = item.Published
+			}
+			pages = appendEntry(pages, item.link(), lastmod)
+		}
+	default:
+		return nil, nil, fmt.Errorf("unknown sitemap format: %s", doc.XMLName.Local)
+	}
+	return pages, sitemaps, nil
+}
+
+// SeedState lastmod of the pages crawled from a Seeder
+//	saved to a file between runs for incremental recrawls
+type SeedState struct {
+	mux   *sync.Mutex
+	pages map[string]time.Time
+
+	// pending lastmod of the pages added but not crawled yet
+	pending map[string]time.Time
+}
+
+// NewSeedState returns an empty *SeedState
+func NewSeedState() *SeedState {
+	return &SeedState{
+		mux:     &sync.Mutex{},
+		pages:   make(map[string]time.Time),
+		pending: make(map[string]time.Time),
+	}
+}
+
+// LoadSeedState load the state saved by Save
+//	returns an empty state when the file does not exist
+func LoadSeedState(filename string) (*SeedState, error) {
+	state := NewSeedState()
+	b, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(b, &state.pages); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// Save write the state to filename
+func (s *SeedState) Save(filename string) error {
+	s.mux.Lock()
+	b, err := json.Marshal(s.pages)
+	s.mux.Unlock()
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, b, 0644)
+}
+
+// Done record the page of ctx as crawled, the next runs skip it until it changes
+//	use it as JobOptions.SucceedFunc or call it from SucceedFunc,
+//	pages that failed are added again by the next run
+func (s *SeedState) Done(ctx *Context) {
+	if ctx == nil || ctx.Task == nil {
+		return
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if lastmod, ok := s.pending[ctx.Task.Url]; ok {
+		delete(s.pending, ctx.Task.Url)
+		s.pages[ctx.Task.Url] = lastmod
+	}
+}
+
+/*
+private
+*/
+
+// changed returns whether the entry is new or changed since it was recorded
+func (s *SeedState) changed(entry *SitemapEntry) bool {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	last, ok := s.pages[entry.Loc]
+	return !ok || entry.LastMod.After(last)
+}
+
+// add record the entry as added and waiting for Done
+func (s *SeedState) add(entry *SitemapEntry) {
+	s.mux.Lock()
+	s.pending[entry.Loc] = entry.LastMod
+	s.mux.Unlock()
+}
+
+type sitemapDocument struct {
+	XMLName xml.Name
+
+	// urlset
+	URLs []sitemapLoc `xml:"url"`
+
+	// sitemapindex
+	Sitemaps []sitemapLoc `xml:"sitemap"`
+
+	// rss 2.0
+	Channel *struct {
+		Items []feedItem `xml:"item"`
+	} `xml:"channel"`
+
+	// rss 1.0 (RDF)
+	Items []feedItem `xml:"item"`
+
+	// atom
+	Entries []atomEntry `xml:"entry"`
+}
+
+type sitemapLoc struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod"`
+}
+
+type feedItem struct {
+	Link    string `xml:"link"`
+	PubDate string `xml:"pubDate"`
+	Date    string `xml:"date"`
+	GUID    struct {
+		Value       string `xml:",chardata"`
+		IsPermaLink string `xml:"isPermaLink,attr"`
+	} `xml:"guid"`
+}
+
+type atomEntry struct {
+	Links []struct {
+		Href string `xml:"href,attr"`
+		Rel  string `xml:"rel,attr"`
+	} `xml:"link"`
+	Updated   string `xml:"updated"`
+	Published string `xml:"published"`
+}
+
+// link returns the alternate link of the entry
+func (e atomEntry) link() string {
+	for _, item := range e.Links {
+		if item.Rel == "" || item.Rel == "alternate" {
+			return item.Href
+		}
+	}
+	return ""
+}
+
+func appendEntry(list []*SitemapEntry, loc, lastmod string) []*SitemapEntry {
+	loc = strings.TrimSpace(loc)
+	if loc == "" {
+		return list
+	}
+	return append(list, &SitemapEntry{Loc: loc, LastMod: parseLastmod(lastmod)})
+}
+
+func parseLastmod(s string) time.Time {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}
+	}
+	for _, layout := range lastmodLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
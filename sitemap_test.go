@@ -0,0 +1,158 @@
+package esme
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_SeederSeedSite(t *testing.T) {
+	lastmod := "2022-05-01"
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/robots.txt":
+			_, _ = fmt.Fprintf(w, "User-agent: *\nDisallow:\nSitemap: %s/sitemap_index.xml\n", ts.URL)
+		case "/sitemap_index.xml":
+			_, _ = fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+<sitemap><loc>%s/pages.xml.gz</loc><lastmod>%s</lastmod></sitemap>
+<sitemap><loc>%s/feed.xml</loc></sitemap>
+</sitemapindex>`, ts.URL, lastmod, ts.URL)
+		case "/pages.xml.gz":
+			var buf bytes.Buffer
+			zw := gzip.NewWriter(&buf)
+			_, _ = fmt.Fprintf(zw, `<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+<url><loc>%s/a</loc><lastmod>%s</lastmod></url>
+<url><loc>%s/b</loc><lastmod>2020-01-01T08:00:00+08:00</lastmod></url>
+</urlset>`, ts.URL, lastmod, ts.URL)
+			_ = zw.Close()
+			_, _ = w.Write(buf.Bytes())
+		case "/feed.xml":
+			_, _ = fmt.Fprintf(w, `<feed xmlns="http://www.w3.org/2005/Atom">
+<entry><link rel="alternate" href="%s/c"/><updated>2022-05-02T10:00:00Z</updated></entry>
+</feed>`, ts.URL)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	since := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	state := NewSeedState()
+	queue := NewMemQueue()
+	seeder := NewSeeder(queue)
+	seeder.State = state
+	seeder.Since = since
+
+	n, err := seeder.SeedSite(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 || queue.Size() != 2 {
+		t.Fatalf("first run: got %d tasks", n)
+	}
+
+	// not crawled yet, the next run adds the pages again
+	seeder = NewSeeder(NewMemQueue())
+	seeder.State = state
+	seeder.Since = since
+	if n, _ = seeder.SeedSite(ts.URL); n != 2 {
+		t.Fatalf("run without crawl: got %d tasks", n)
+	}
+	for task := queue.Pop(); task != nil; task = queue.Pop() {
+		state.Done(&Context{Task: task})
+	}
+
+	filename := filepath.Join(t.TempDir(), "state.json")
+	if err = state.Save(filename); err != nil {
+		t.Fatal(err)
+	}
+	state, err = LoadSeedState(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// nothing changed
+	seeder = NewSeeder(NewMemQueue())
+	seeder.State = state
+	seeder.Since = since
+	if n, _ = seeder.SeedSite(ts.URL); n != 0 {
+		t.Fatalf("second run: got %d tasks", n)
+	}
+
+	// page a changed
+	lastmod = "2022-06-01"
+	queue = NewMemQueue()
+	seeder = NewSeeder(queue)
+	seeder.State = state
+	seeder.Since = since
+	if n, _ = seeder.SeedSite(ts.URL); n != 1 {
+		t.Fatalf("third run: got %d tasks", n)
+	}
+	if task := queue.Pop(); task.Url != ts.URL+"/a" {
+		t.Fatalf("third run: got %s", task.Url)
+	}
+}
+
+func Test_SeederFailedSitemap(t *testing.T) {
+	var (
+		ts   *httptest.Server
+		fail = true
+	)
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/index.xml":
+			_, _ = fmt.Fprintf(w, `<sitemapindex><sitemap><loc>%s/pages.xml</loc><lastmod>2022-05-01</lastmod></sitemap></sitemapindex>`, ts.URL)
+		case "/pages.xml":
+			if fail {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			_, _ = fmt.Fprintf(w, `<urlset><url><loc>%s/a</loc></url></urlset>`, ts.URL)
+		case "/bomb.xml.gz":
+			var buf bytes.Buffer
+			zw := gzip.NewWriter(&buf)
+			_, _ = zw.Write(make([]byte, maxSitemapSize+1))
+			_ = zw.Close()
+			_, _ = w.Write(buf.Bytes())
+		}
+	}))
+	defer ts.Close()
+
+	// the nested sitemap failed, it is read again by the next run
+	state := NewSeedState()
+	seeder := NewSeeder(NewMemQueue())
+	seeder.State = state
+	if n, _ := seeder.Seed(ts.URL + "/index.xml"); n != 0 {
+		t.Fatalf("first run: got %d tasks", n)
+	}
+	fail = false
+	if n, _ := seeder.Seed(ts.URL + "/index.xml"); n != 1 {
+		t.Fatalf("second run: got %d tasks", n)
+	}
+
+	if _, err := seeder.Entries(ts.URL + "/bomb.xml.gz"); err == nil || !strings.Contains(err.Error(), "exceeds") {
+		t.Fatalf("err %v", err)
+	}
+}
+
+func Test_ParseSitemapRSS(t *testing.T) {
+	pages, _, err := ParseSitemap([]byte(`<?xml version="1.0" encoding="gbk"?><rss version="2.0"><channel>
+<title>news</title><link>https://www.example.com/</link>
+<item><title>1</title><link>https://www.example.com/1</link><pubDate>Mon, 02 May 2022 10:00:00 +0800</pubDate></item>
+<item><title>2</title><guid>https://www.example.com/2</guid></item>
+</channel></rss>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 2 || pages[1].Loc != "https://www.example.com/2" || pages[0].LastMod.Day() != 2 {
+		t.Fatalf("pages: got %v", pages)
+	}
+}
@@ -0,0 +1,110 @@
+/*
+stats.go
+job statistics
+*/
+
+package esme
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// JobStats statistics of a job
+type JobStats struct {
+
+	// Total tasks executed
+	Total int64 `json:"total"`
+
+	// Succeed tasks with a success status code
+	Succeed int64 `json:"succeed"`
+
+	// Retry tasks that ended with a retry status code
+	Retry int64 `json:"retry"`
+
+	// Failed tasks with a fail status code
+	Failed int64 `json:"failed"`
+
+	// Error tasks whose request returned an error
+	Error int64 `json:"error"`
+
+	// Cancelled tasks whose request was cancelled
+	Cancelled int64 `json:"cancelled"`
+
+	// Disallowed tasks skipped by robots.txt
+	Disallowed int64 `json:"disallowed"`
+
+	// Timing timings of the requests with a response
+	Timing TimingStats `json:"timing"`
+
+	// Proxies timings of the requests by proxy, "" is without proxy
+	//	compare them with Timing to tell slow proxies from slow targets
+	Proxies map[string]TimingStats `json:"proxies"`
+
+	mux *sync.Mutex
+}
+
+// newJobStats returns a new *JobStats
+func newJobStats() *JobStats {
+	return &JobStats{
+		Proxies: make(map[string]TimingStats),
+		mux:     &sync.Mutex{},
+	}
+}
+
+// String returns the statistics in one line
+func (s JobStats) String() string {
+	avg := s.Timing.Average()
+	return fmt.Sprintf("total: %d succeed: %d retry: %d failed: %d error: %d cancelled: %d disallowed: %d avg first byte: %v avg total: %v",
+		s.Total, s.Succeed, s.Retry, s.Failed, s.Error, s.Cancelled, s.Disallowed, avg.FirstByte, avg.Total)
+}
+
+// add count a finished context
+func (s *JobStats) add(ctx *Context) {
+	atomic.AddInt64(&s.Total, 1)
+	switch ctx.outcome {
+	case OutcomeSuccess:
+		atomic.AddInt64(&s.Succeed, 1)
+	case OutcomeRetry:
+		atomic.AddInt64(&s.Retry, 1)
+	case OutcomeFail:
+		atomic.AddInt64(&s.Failed, 1)
+	case OutcomeError:
+		atomic.AddInt64(&s.Error, 1)
+	case OutcomeCancelled:
+		atomic.AddInt64(&s.Cancelled, 1)
+	}
+
+	if ctx.Response == nil {
+		return
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.Timing.add(ctx.timings)
+	proxy := s.Proxies[ctx.timings.Proxy]
+	proxy.add(ctx.timings)
+	s.Proxies[ctx.timings.Proxy] = proxy
+}
+
+// snapshot returns a copy that is safe to read
+func (s *JobStats) snapshot() JobStats {
+	s.mux.Lock()
+	timing := s.Timing
+	proxies := make(map[string]TimingStats, len(s.Proxies))
+	for k, v := range s.Proxies {
+		proxies[k] = v
+	}
+	s.mux.Unlock()
+	return JobStats{
+		Timing:     timing,
+		Proxies:    proxies,
+		Total:      atomic.LoadInt64(&s.Total),
+		Succeed:    atomic.LoadInt64(&s.Succeed),
+		Retry:      atomic.LoadInt64(&s.Retry),
+		Failed:     atomic.LoadInt64(&s.Failed),
+		Error:      atomic.LoadInt64(&s.Error),
+		Cancelled:  atomic.LoadInt64(&s.Cancelled),
+		Disallowed: atomic.LoadInt64(&s.Disallowed),
+	}
+}
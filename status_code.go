@@ -10,15 +10,19 @@ type StatusCode map[int]string
 var (
 
 	// statusCodeMap http response status code
+	//	3xx responses reach the callbacks only when redirects are not followed
 	statusCode = StatusCode{
 		200: "success",
 		201: "success",
 		202: "success",
 		203: "success",
 		204: "fail",
-		301: "success",
-		302: "success",
-		307: "success",
+		300: "redirect",
+		301: "redirect",
+		302: "redirect",
+		303: "redirect",
+		307: "redirect",
+		308: "redirect",
 		400: "fail",
 		401: "retry",
 		402: "retry",
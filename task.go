@@ -7,6 +7,7 @@ http task
 package esme
 
 import (
+	"encoding/json"
 	"net/http"
 )
 
@@ -25,9 +26,27 @@ type Task struct {
 	// FormData request formData
 	FormData FormData `json:"form_data"`
 
+	// JSON request json body, sent with a json Content-Type
+	JSON json.RawMessage `json:"json,omitempty"`
+
+	// Multipart request multipart/form-data
+	Multipart *MultipartForm `json:"multipart,omitempty"`
+
 	// header *http.Header
 	Header *http.Header `json:"header"`
 
+	// Proxy http proxy of the task, overrides the proxy of the job
+	Proxy string `json:"proxy,omitempty"`
+
+	// Session session of the task, tasks of a session keep the same browser profile
+	Session string `json:"session,omitempty"`
+
+	// Paginator generate the task of the next page after a successful response
+	Paginator *Paginator `json:"paginator,omitempty"`
+
+	// Depth link depth from the seed task, used by Crawler
+	Depth int `json:"depth"`
+
 	// Data Contextual data passing
 	Data map[string]interface{}
 }
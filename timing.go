@@ -0,0 +1,205 @@
+/*
+timing.go
+timings of a request collected with httptrace
+*/
+
+package esme
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// Timings timings of the request of a Context
+//	the phases of every redirect are added up
+type Timings struct {
+
+	// DNS time resolving host names
+	DNS time.Duration `json:"dns"`
+
+	// Connect time opening tcp connections
+	Connect time.Duration `json:"connect"`
+
+	// TLS time of tls handshakes
+	TLS time.Duration `json:"tls"`
+
+	// FirstByte time from the start of the request to the first byte of the last response
+	FirstByte time.Duration `json:"first_byte"`
+
+	// Body time reading the response body
+	Body time.Duration `json:"body"`
+
+	// Total time from the start of the request to the end of the body
+	Total time.Duration `json:"total"`
+
+	// RemoteAddr address of the last connection, the proxy when a proxy is used
+	RemoteAddr string `json:"remote_addr"`
+
+	// Reused the last connection was reused
+	Reused bool `json:"reused"`
+
+	// Proxy proxy used by the request, the password is masked
+	Proxy string `json:"proxy,omitempty"`
+}
+
+// String returns the timings in one line
+func (t Timings) String() string {
+	s := fmt.Sprintf("dns: %v connect: %v tls: %v first byte: %v body: %v total: %v remote: %s reused: %v",
+		t.DNS, t.Connect, t.TLS, t.FirstByte, t.Body, t.Total, t.RemoteAddr, t.Reused)
+	if t.Proxy != "" {
+		s += " proxy: " + t.Proxy
+	}
+	return s
+}
+
+// Timings returns the timings of the last request
+func (c *Context) Timings() Timings {
+	return c.timings
+}
+
+// TimingStats sums of the timings of requests
+type TimingStats struct {
+
+	// Count number of requests
+	Count int64 `json:"count"`
+
+	DNS time.Duration `json:"dns"`
+
+	Connect time.Duration `json:"connect"`
+
+	TLS time.Duration `json:"tls"`
+
+	FirstByte time.Duration `json:"first_byte"`
+
+	Body time.Duration `json:"body"`
+
+	Total time.Duration `json:"total"`
+}
+
+// Average returns the average timings of the requests
+func (s TimingStats) Average() Timings {
+	if s.Count == 0 {
+		return Timings{}
+	}
+	n := time.Duration(s.Count)
+	return Timings{
+		DNS:       s.DNS / n,
+		Connect:   s.Connect / n,
+		TLS:       s.TLS / n,
+		FirstByte: s.FirstByte / n,
+		Body:      s.Body / n,
+		Total:     s.Total / n,
+	}
+}
+
+/*
+private
+*/
+
+func (s *TimingStats) add(t Timings) {
+	s.Count++
+	s.DNS += t.DNS
+	s.Connect += t.Connect
+	s.TLS += t.TLS
+	s.FirstByte += t.FirstByte
+	s.Body += t.Body
+	s.Total += t.Total
+}
+
+// tracer collect the timings of a request
+type tracer struct {
+	mux sync.Mutex
+
+	timings Timings
+
+	start, dnsStart, connectStart, tlsStart, firstByte time.Time
+}
+
+func newTracer() *tracer {
+	return &tracer{start: time.Now()}
+}
+
+// clientTrace returns the hooks of the tracer
+//	connections may be dialed in parallel, so hooks lock the tracer
+func (tr *tracer) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			tr.mux.Lock()
+			tr.dnsStart = time.Now()
+			tr.mux.Unlock()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			tr.mux.Lock()
+			tr.timings.DNS += since(&tr.dnsStart)
+			tr.mux.Unlock()
+		},
+		ConnectStart: func(string, string) {
+			tr.mux.Lock()
+			if tr.connectStart.IsZero() {
+				tr.connectStart = time.Now()
+			}
+			tr.mux.Unlock()
+		},
+		ConnectDone: func(_, _ string, err error) {
+			tr.mux.Lock()
+			if err == nil {
+				tr.timings.Connect += since(&tr.connectStart)
+			}
+			tr.mux.Unlock()
+		},
+		TLSHandshakeStart: func() {
+			tr.mux.Lock()
+			tr.tlsStart = time.Now()
+			tr.mux.Unlock()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			tr.mux.Lock()
+			tr.timings.TLS += since(&tr.tlsStart)
+			tr.mux.Unlock()
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			tr.mux.Lock()
+			if info.Conn != nil {
+				tr.timings.RemoteAddr = info.Conn.RemoteAddr().String()
+			}
+			tr.timings.Reused = info.Reused
+			tr.mux.Unlock()
+		},
+		GotFirstResponseByte: func() {
+			tr.mux.Lock()
+			tr.firstByte = time.Now()
+			tr.mux.Unlock()
+		},
+	}
+}
+
+// finish returns the timings of a request
+//	header is when the response was returned, end when the body was read
+func (tr *tracer) finish(header, end time.Time) Timings {
+	tr.mux.Lock()
+	defer tr.mux.Unlock()
+	t := tr.timings
+	firstByte := tr.firstByte
+	if firstByte.IsZero() || firstByte.Before(tr.start) {
+		firstByte = header
+	}
+	if !header.IsZero() {
+		t.FirstByte = firstByte.Sub(tr.start)
+		t.Body = end.Sub(header)
+	}
+	t.Total = end.Sub(tr.start)
+	return t
+}
+
+// since returns the time since *start and clears it
+func since(start *time.Time) time.Duration {
+	if start.IsZero() {
+		return 0
+	}
+	d := time.Since(*start)
+	*start = time.Time{}
+	return d
+}
@@ -0,0 +1,50 @@
+package esme
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_Timings(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("head "))
+		w.(http.Flusher).Flush()
+		time.Sleep(50 * time.Millisecond)
+		_, _ = w.Write([]byte("body"))
+	}))
+	defer ts.Close()
+
+	ctx := HttpGet(ts.URL)
+	if err := ctx.Do(); err != nil {
+		t.Fatal(err)
+	}
+	timings := ctx.Timings()
+	if timings.Body < 40*time.Millisecond || timings.FirstByte <= 0 || timings.FirstByte >= timings.Total {
+		t.Fatalf("timings %s", timings)
+	}
+	if ctx.GetExecTime() != timings.Total || timings.Connect <= 0 || timings.Reused {
+		t.Fatalf("timings %s", timings)
+	}
+	if timings.RemoteAddr != ts.Listener.Addr().String() {
+		t.Fatalf("remote %s", timings.RemoteAddr)
+	}
+
+	// the second request reuses the connection
+	if err := ctx.Do(); err != nil {
+		t.Fatal(err)
+	}
+	if !ctx.Timings().Reused || ctx.Timings().Connect != 0 {
+		t.Fatalf("timings %s", ctx.Timings())
+	}
+
+	queue := NewMemQueue()
+	queue.AddTasks([]*Task{{Url: ts.URL, Method: "GET"}, {Url: ts.URL, Method: "GET"}})
+	job := NewJob("timings", 1, queue, JobOptions{})
+	job.Do()
+	stats := job.Stats()
+	if stats.Timing.Count != 2 || stats.Proxies[""].Count != 2 || stats.Timing.Average().Body < 40*time.Millisecond {
+		t.Fatalf("stats %+v", stats)
+	}
+}
@@ -0,0 +1,296 @@
+/*
+tls.go
+tls options of requests and browser like ClientHello profiles
+*/
+
+package esme
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+)
+
+const (
+
+	// TLSProfileChrome ClientHello like chrome
+	TLSProfileChrome = "chrome"
+
+	// TLSProfileFirefox ClientHello like firefox
+	TLSProfileFirefox = "firefox"
+
+	// TLSProfileSafari ClientHello like safari
+	TLSProfileSafari = "safari"
+
+	// TLSProfileGo ClientHello of crypto/tls
+	TLSProfileGo = "go"
+)
+
+// TLSProfile settings of the ClientHello sent by the requests
+//	crypto/tls decides the order of the extensions and does not send GREASE values,
+//	so a profile only resembles the browser: same versions, cipher suites, curves and ALPN
+type TLSProfile struct {
+
+	// MinVersion min tls version, like tls.VersionTLS12
+	MinVersion uint16
+
+	// MaxVersion max tls version, like tls.VersionTLS13
+	MaxVersion uint16
+
+	// CipherSuites tls 1.0-1.2 cipher suites offered, tls 1.3 suites are not configurable
+	CipherSuites []uint16
+
+	// CurvePreferences key exchange curves offered
+	CurvePreferences []tls.CurveID
+
+	// HTTP2 offer h2 with ALPN and use http/2 when the server selects it
+	HTTP2 bool
+}
+
+// TLSOptions tls settings of the requests
+//	certificates are verified unless Insecure is set,
+//	explicit fields override the values of Profile
+type TLSOptions struct {
+
+	// Insecure skip the verification of the server certificate
+	Insecure bool
+
+	// RootCAs pem files of root certificates trusted besides the system ones
+	RootCAs []string
+
+	// RootCAPEM pem data of root certificates trusted besides the system ones
+	RootCAPEM []byte
+
+	// CertFile pem file of the client certificate (mTLS)
+	CertFile string
+
+	// KeyFile pem file of the key of the client certificate
+	KeyFile string
+
+	// Certificates client certificates, used with CertFile
+	Certificates []tls.Certificate
+
+	// ServerName name verified in the server certificate, the host of the request by default
+	ServerName string
+
+	// MinVersion min tls version, like tls.VersionTLS12
+	MinVersion uint16
+
+	// MaxVersion max tls version, like tls.VersionTLS13
+	MaxVersion uint16
+
+	// CipherSuites tls 1.0-1.2 cipher suites offered
+	CipherSuites []uint16
+
+	// Profile name of a TLSProfile, like TLSProfileChrome
+	Profile string
+
+	mux sync.Mutex
+
+	// config built by Config, the files are read once
+	config *tls.Config
+
+	http2 bool
+}
+
+var (
+	tlsProfileMux sync.RWMutex
+
+	// tlsProfiles profiles by name, the cipher suites follow the order of the browsers
+	tlsProfiles = map[string]*TLSProfile{
+		TLSProfileChrome: {
+			MinVersion: tls.VersionTLS12,
+			MaxVersion: tls.VersionTLS13,
+			CipherSuites: []uint16{
+				tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+				tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+				tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+				tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+				tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+				tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+				tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+				tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+				tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+				tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+				tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+				tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+			},
+			CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384},
+			HTTP2:            true,
+		},
+		TLSProfileFirefox: {
+			MinVersion: tls.VersionTLS12,
+			MaxVersion: tls.VersionTLS13,
+			CipherSuites: []uint16{
+				tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+				tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+				tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+				tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+				tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+				tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+				tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+				tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+				tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+				tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+				tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+				tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+				tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+				tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+			},
+			CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384, tls.CurveP521},
+			HTTP2:            true,
+		},
+		TLSProfileSafari: {
+			MinVersion: tls.VersionTLS12,
+			MaxVersion: tls.VersionTLS13,
+			CipherSuites: []uint16{
+				tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+				tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+				tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+				tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+				tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+				tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+				tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+				tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+				tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+				tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+				tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+				tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+				tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+				tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+			},
+			CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384, tls.CurveP521},
+			HTTP2:            true,
+		},
+		TLSProfileGo: {},
+	}
+)
+
+// RegisterTLSProfile add or replace the profile of name
+func RegisterTLSProfile(name string, profile *TLSProfile) {
+	if name == "" || profile == nil {
+		return
+	}
+	tlsProfileMux.Lock()
+	tlsProfiles[name] = profile
+	tlsProfileMux.Unlock()
+}
+
+// GetTLSProfile returns the profile of name, nil when it does not exist
+func GetTLSProfile(name string) *TLSProfile {
+	tlsProfileMux.RLock()
+	defer tlsProfileMux.RUnlock()
+	return tlsProfiles[name]
+}
+
+// Config returns the *tls.Config of the options
+//	the config is built once, later changes of the options are ignored
+func (o *TLSOptions) Config() (*tls.Config, error) {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+	if o.config == nil {
+		config, err := o.build()
+		if err != nil {
+			return nil, err
+		}
+		o.config = config
+	}
+	return o.config.Clone(), nil
+}
+
+// SetTLS set the tls options of the request
+//	an invalid option (missing file, bad pem) is returned by Do as ErrorTLS
+func (c *Context) SetTLS(options *TLSOptions) *Context {
+	if options == nil {
+		return c
+	}
+	if _, err := options.Config(); err != nil {
+		c.buildErr = newRequestError(ErrorTLS, err)
+		return c
+	}
+	c.tls = options
+	if transport, ok := c.client.Transport.(*http.Transport); ok {
+		c.configureTransport(transport)
+	}
+	return c
+}
+
+/*
+private
+*/
+
+// build returns a new *tls.Config of the options
+func (o *TLSOptions) build() (*tls.Config, error) {
+	config := &tls.Config{
+		InsecureSkipVerify: o.Insecure,
+		ServerName:         o.ServerName,
+	}
+
+	if o.Profile != "" {
+		profile := GetTLSProfile(o.Profile)
+		if profile == nil {
+			return nil, fmt.Errorf("tls profile not found: %s", o.Profile)
+		}
+		config.MinVersion = profile.MinVersion
+		config.MaxVersion = profile.MaxVersion
+		config.CipherSuites = profile.CipherSuites
+		config.CurvePreferences = profile.CurvePreferences
+		o.http2 = profile.HTTP2
+	}
+	if o.MinVersion > 0 {
+		config.MinVersion = o.MinVersion
+	}
+	if o.MaxVersion > 0 {
+		config.MaxVersion = o.MaxVersion
+	}
+	if len(o.CipherSuites) > 0 {
+		config.CipherSuites = o.CipherSuites
+	}
+
+	if len(o.RootCAs) > 0 || len(o.RootCAPEM) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		for _, file := range o.RootCAs {
+			b, err := ioutil.ReadFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("read root ca: %s", err.Error())
+			}
+			if !pool.AppendCertsFromPEM(b) {
+				return nil, fmt.Errorf("no certificate found in %s", file)
+			}
+		}
+		if len(o.RootCAPEM) > 0 && !pool.AppendCertsFromPEM(o.RootCAPEM) {
+			return nil, errors.New("no certificate found in RootCAPEM")
+		}
+		config.RootCAs = pool
+	}
+
+	config.Certificates = append(config.Certificates, o.Certificates...)
+	if o.CertFile != "" || o.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %s", err.Error())
+		}
+		config.Certificates = append(config.Certificates, cert)
+	}
+	return config, nil
+}
+
+// configureTransport apply the resolver and the tls options of the context to transport
+func (c *Context) configureTransport(transport *http.Transport) {
+	if c.resolver != nil {
+		transport.DialContext = c.resolver.DialContext
+	}
+	if c.tls != nil {
+		// checked by SetTLS
+		config, _ := c.tls.Config()
+		transport.TLSClientConfig = config
+		transport.ForceAttemptHTTP2 = c.tls.http2
+	}
+}
@@ -0,0 +1,133 @@
+package esme
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeClientCert write a self-signed client certificate and its key to dir
+func writeClientCert(t *testing.T, dir string) (*x509.Certificate, string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "esme client"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+
+	certFile, keyFile := filepath.Join(dir, "client.pem"), filepath.Join(dir, "client.key")
+	_ = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	_ = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	return cert, certFile, keyFile
+}
+
+func Test_TLSVerify(t *testing.T) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Proto))
+	}))
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+
+	// verified by default, http/2 only with a profile offering it
+	err := HttpGet(ts.URL).Do()
+	var reqErr *RequestError
+	if !errors.As(err, &reqErr) || reqErr.Kind != ErrorTLS {
+		t.Fatalf("err %v", err)
+	}
+
+	ctx := HttpGet(ts.URL).SetTLS(&TLSOptions{Insecure: true})
+	if err = ctx.Do(); err != nil || ctx.ToString() != "HTTP/1.1" {
+		t.Fatalf("err %v body %s", err, ctx.ToString())
+	}
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	_ = ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}), 0600)
+	options := &TLSOptions{RootCAs: []string{caFile}, Profile: TLSProfileChrome}
+	ctx = HttpGet(ts.URL).SetTLS(options)
+	if err = ctx.Do(); err != nil || ctx.ToString() != "HTTP/2.0" {
+		t.Fatalf("err %v body %s", err, ctx.ToString())
+	}
+
+	// the options survive a proxy set later
+	ctx = HttpGet(ts.URL).SetTLS(options).SetProxy("http://127.0.0.1:1")
+	if transport := ctx.client.Transport.(*http.Transport); transport.TLSClientConfig.RootCAs == nil {
+		t.Fatal("tls options lost by SetProxy")
+	}
+
+	// invalid options are returned by Do
+	err = HttpGet(ts.URL).SetTLS(&TLSOptions{RootCAs: []string{"missing.pem"}}).Do()
+	if !errors.As(err, &reqErr) || reqErr.Kind != ErrorTLS {
+		t.Fatalf("err %v", err)
+	}
+	err = HttpGet(ts.URL).SetTLS(&TLSOptions{Profile: "netscape"}).Do()
+	if !errors.As(err, &reqErr) || reqErr.Kind != ErrorTLS {
+		t.Fatalf("err %v", err)
+	}
+}
+
+func Test_TLSClientCert(t *testing.T) {
+	cert, certFile, keyFile := writeClientCert(t, t.TempDir())
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	ts.TLS = &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  pool,
+		MaxVersion: tls.VersionTLS12,
+	}
+	ts.StartTLS()
+	defer ts.Close()
+
+	ctx := HttpGet(ts.URL).SetTLS(&TLSOptions{Insecure: true, CertFile: certFile, KeyFile: keyFile})
+	if err := ctx.Do(); err != nil || ctx.ToString() != "esme client" {
+		t.Fatalf("err %v body %s", err, ctx.ToString())
+	}
+
+	// the server does not speak tls 1.3
+	err := HttpGet(ts.URL).SetTLS(&TLSOptions{Insecure: true, CertFile: certFile, KeyFile: keyFile, MinVersion: tls.VersionTLS13}).Do()
+	var reqErr *RequestError
+	if !errors.As(err, &reqErr) || reqErr.Kind != ErrorTLS {
+		t.Fatalf("err %v", err)
+	}
+}
+
+func Test_TLSProfile(t *testing.T) {
+	RegisterTLSProfile("legacy", &TLSProfile{MaxVersion: tls.VersionTLS12})
+	config, err := (&TLSOptions{Profile: "legacy", MinVersion: tls.VersionTLS11}).Config()
+	if err != nil || config.MaxVersion != tls.VersionTLS12 || config.MinVersion != tls.VersionTLS11 {
+		t.Fatalf("config %v err %v", config, err)
+	}
+	if p := GetTLSProfile(TLSProfileFirefox); p == nil || len(p.CurvePreferences) != 4 {
+		t.Fatalf("profile %v", p)
+	}
+}
@@ -0,0 +1,203 @@
+/*
+url_template.go
+generate tasks from a url template and lists of parameter values
+*/
+
+package esme
+
+import (
+	"fmt"
+	"net/http"
+	burl "net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+
+	// templateParamRegexp {name} in a url template
+	templateParamRegexp = regexp.MustCompile(`\{(\w+)\}`)
+)
+
+// URLTemplate url template with {name} placeholders
+//	generates the cartesian product of the values of its parameters,
+//	the first parameter changes slowest
+//	like: https://example.com/api/{city}?page={page}
+type URLTemplate struct {
+	tmpl string
+
+	params []*templateParam
+}
+
+type templateParam struct {
+	name   string
+	values []string
+}
+
+// NewURLTemplate returns a new *URLTemplate
+func NewURLTemplate(tmpl string) *URLTemplate {
+	return &URLTemplate{
+		tmpl:   tmpl,
+		params: make([]*templateParam, 0),
+	}
+}
+
+// Values set the values of a parameter
+func (t *URLTemplate) Values(name string, values ...string) *URLTemplate {
+	for _, p := range t.params {
+		if p.name == name {
+			p.values = values
+			return t
+		}
+	}
+	t.params = append(t.params, &templateParam{name: name, values: values})
+	return t
+}
+
+// Range set the values of a parameter to the numbers from start to end (included)
+//	like: Range("page", 1, 10, 1)
+func (t *URLTemplate) Range(name string, start, end, step int) *URLTemplate {
+	if step == 0 {
+		step = 1
+	}
+	values := make([]string, 0)
+	for i := start; (step > 0 && i <= end) || (step < 0 && i >= end); i += step {
+		values = append(values, strconv.Itoa(i))
+	}
+	return t.Values(name, values...)
+}
+
+// Each call fn with every url of the template and the parameters used
+//	path parameters are escaped as path segments, the others as query values
+func (t *URLTemplate) Each(fn func(url string, params map[string]string)) error {
+	if err := t.check(); err != nil {
+		return err
+	}
+	if len(t.params) == 0 {
+		fn(t.tmpl, map[string]string{})
+		return nil
+	}
+	for _, p := range t.params {
+		if len(p.values) == 0 {
+			return nil
+		}
+	}
+
+	index := make([]int, len(t.params))
+	for {
+		params := make(map[string]string, len(t.params))
+		for i, p := range t.params {
+			params[p.name] = p.values[index[i]]
+		}
+		fn(t.expand(params), params)
+
+		// next combination, the last parameter changes fastest
+		i := len(index) - 1
+		for ; i >= 0; i-- {
+			index[i]++
+			if index[i] < len(t.params[i].values) {
+				break
+			}
+			index[i] = 0
+		}
+		if i < 0 {
+			return nil
+		}
+	}
+}
+
+// URLs returns all the urls of the template
+func (t *URLTemplate) URLs() ([]string, error) {
+	urls := make([]string, 0)
+	err := t.Each(func(url string, params map[string]string) {
+		urls = append(urls, url)
+	})
+	return urls, err
+}
+
+// Tasks returns a task of every url of the template
+//	tasks are copies of base with the parameters in Data, base can be nil
+func (t *URLTemplate) Tasks(base *Task) ([]*Task, error) {
+	tasks := make([]*Task, 0)
+	err := t.Each(func(url string, params map[string]string) {
+		task := copyTask(base)
+		task.Url = url
+		for k, v := range params {
+			task.Data[k] = v
+		}
+		tasks = append(tasks, task)
+	})
+	return tasks, err
+}
+
+// AddTo add the tasks of the template to queue
+//	returns the number of tasks added
+func (t *URLTemplate) AddTo(queue TodoQueue, base *Task) (int, error) {
+	tasks, err := t.Tasks(base)
+	if err != nil {
+		return 0, err
+	}
+	queue.AddTasks(tasks)
+	return len(tasks), nil
+}
+
+/*
+private
+*/
+
+// check every placeholder has a parameter and every parameter is used
+func (t *URLTemplate) check() error {
+	used := make(map[string]bool)
+	for _, m := range templateParamRegexp.FindAllStringSubmatch(t.tmpl, -1) {
+		used[m[1]] = true
+	}
+	for _, p := range t.params {
+		if !used[p.name] {
+			return fmt.Errorf("url template: parameter {%s} not found in %s", p.name, t.tmpl)
+		}
+		delete(used, p.name)
+	}
+	for name := range used {
+		return fmt.Errorf("url template: no values of parameter {%s}", name)
+	}
+	return nil
+}
+
+// expand build the url with escaped values
+func (t *URLTemplate) expand(params map[string]string) string {
+	query := strings.IndexAny(t.tmpl, "?#")
+	var buf strings.Builder
+	last := 0
+	for _, loc := range templateParamRegexp.FindAllStringSubmatchIndex(t.tmpl, -1) {
+		buf.WriteString(t.tmpl[last:loc[0]])
+		value := params[t.tmpl[loc[2]:loc[3]]]
+		if query >= 0 && loc[0] > query {
+			buf.WriteString(burl.QueryEscape(value))
+		} else {
+			buf.WriteString(burl.PathEscape(value))
+		}
+		last = loc[1]
+	}
+	buf.WriteString(t.tmpl[last:])
+	return buf.String()
+}
+
+// copyTask returns a copy of task for a new url
+func copyTask(task *Task) *Task {
+	if task == nil {
+		return &Task{Method: "GET", Data: make(map[string]interface{})}
+	}
+	t := *task
+	if task.Header != nil {
+		header := task.Header.Clone()
+		t.Header = &header
+	} else {
+		t.Header = &http.Header{}
+	}
+	t.Data = make(map[string]interface{}, len(task.Data))
+	for k, v := range task.Data {
+		t.Data[k] = v
+	}
+	return &t
+}
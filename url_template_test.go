@@ -0,0 +1,53 @@
+package esme
+
+import (
+	"net/http"
+	"testing"
+)
+
+func Test_URLTemplate(t *testing.T) {
+	tmpl := NewURLTemplate("https://example.com/api/{city}?page={page}&q={q}").
+		Values("city", "成都", "a/b").
+		Range("page", 1, 2, 1).
+		Values("q", "x y")
+	urls, err := tmpl.URLs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"https://example.com/api/%E6%88%90%E9%83%BD?page=1&q=x+y",
+		"https://example.com/api/%E6%88%90%E9%83%BD?page=2&q=x+y",
+		"https://example.com/api/a%2Fb?page=1&q=x+y",
+		"https://example.com/api/a%2Fb?page=2&q=x+y",
+	}
+	if len(urls) != len(want) {
+		t.Fatalf("got %v", urls)
+	}
+	for i := range want {
+		if urls[i] != want[i] {
+			t.Fatalf("%d: got %s want %s", i, urls[i], want[i])
+		}
+	}
+
+	header := http.Header{}
+	header.Set("User-Agent", "esme")
+	queue := NewMemQueue()
+	n, err := tmpl.AddTo(queue, &Task{Method: "POST", Header: &header, Data: map[string]interface{}{"source": "t"}})
+	if err != nil || n != 4 || queue.Size() != 4 {
+		t.Fatalf("added %d: %v", n, err)
+	}
+	task := queue.Pop()
+	if task.Method != "POST" || task.Header.Get("User-Agent") != "esme" || task.Data["city"] != "成都" || task.Data["source"] != "t" {
+		t.Fatalf("got %+v", task)
+	}
+
+	if _, err = NewURLTemplate("https://example.com/{id}").URLs(); err == nil {
+		t.Fatal("missing parameter should fail")
+	}
+	if _, err = NewURLTemplate("https://example.com/").Values("id", "1").URLs(); err == nil {
+		t.Fatal("unknown parameter should fail")
+	}
+	if urls, _ = NewURLTemplate("https://example.com/{id}").Range("id", 5, 1, -2).URLs(); len(urls) != 3 || urls[2] != "https://example.com/1" {
+		t.Fatalf("got %v", urls)
+	}
+}
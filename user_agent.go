@@ -8,9 +8,7 @@ sam
 package esme
 
 import (
-	"math/rand"
 	"strings"
-	"time"
 )
 
 // UserAgentType user-agent类型
@@ -53,18 +51,125 @@ var (
 	}
 )
 
+// UserAgentMode how a job chooses the user-agent of the requests
+type UserAgentMode string
+
+const (
+
+	// UAFixed the first user-agent of the list
+	UAFixed UserAgentMode = "fixed"
+
+	// UARandom a random user-agent for every request
+	UARandom UserAgentMode = "random"
+
+	// UAPerProxy the same random user-agent for the requests of a proxy
+	UAPerProxy UserAgentMode = "proxy"
+
+	// UAPerSession the same random user-agent for the tasks of a session (Task.Session),
+	//	tasks without a session get a random user-agent
+	UAPerSession UserAgentMode = "session"
+
+	// UAWeighted a user-agent chosen by Weights for every request
+	UAWeighted UserAgentMode = "weighted"
+)
+
+// UserAgentPolicy user-agent of the requests of a job
+//	applied to tasks without a User-Agent header, ignored when JobOptions.BrowserProfiles is set
+type UserAgentPolicy struct {
+
+	// Mode how the user-agent is chosen, UARandom by default
+	Mode UserAgentMode
+
+	// UserAgents user-agents to choose from, the built-in list of Type when empty
+	UserAgents []string
+
+	// Weights weights of UserAgents, random choices are weighted when it has the same length
+	Weights []int
+
+	// Type PCUserAgent or MobileUserAgent, the built-in list to choose from, all when 0
+	Type UserAgentType
+}
+
 // RandomUserAgent 取一个随机的user-agent
 func RandomUserAgent(userAgentType UserAgentType) string {
 	items := getUserAgentList(userAgentType)
-	r := rand.New(rand.NewSource(time.Now().UnixNano()))
-	i := r.Intn(len(items))
-	return items[i]
+	if len(items) == 0 {
+		return ""
+	}
+	return items[randIntn(len(items))]
+}
+
+// UserAgent returns the User-Agent header of the request
+func (c *Context) UserAgent() string {
+	return c.Request.Header.Get("User-Agent")
+}
+
+// SetUserAgent set the User-Agent header of the request
+func (c *Context) SetUserAgent(ua string) *Context {
+	if ua == "" {
+		return c
+	}
+	c.Request.Header.Set("User-Agent", ua)
+	return c
+}
+
+// Random returns a random user-agent of the policy
+//	weighted when Weights has the same length as UserAgents
+func (p *UserAgentPolicy) Random() string {
+	if len(p.UserAgents) == 0 {
+		return RandomUserAgent(p.Type)
+	}
+	if len(p.Weights) != len(p.UserAgents) {
+		return p.UserAgents[randIntn(len(p.UserAgents))]
+	}
+	total := 0
+	for _, w := range p.Weights {
+		if w > 0 {
+			total += w
+		}
+	}
+	if total == 0 {
+		return p.UserAgents[randIntn(len(p.UserAgents))]
+	}
+	n := randIntn(total)
+	for i, w := range p.Weights {
+		if w <= 0 {
+			continue
+		}
+		if n -= w; n < 0 {
+			return p.UserAgents[i]
+		}
+	}
+	return p.UserAgents[len(p.UserAgents)-1]
+}
+
+/*
+private
+*/
+
+// sticky returns the key of the sticky user-agent, "" when it is chosen for every request
+func (p *UserAgentPolicy) sticky(task *Task, proxy string) string {
+	switch p.Mode {
+	case UAFixed:
+		return "fixed"
+	case UAPerProxy:
+		return "proxy:" + proxy
+	case UAPerSession:
+		if task.Session != "" {
+			return "session:" + task.Session
+		}
+	}
+	return ""
 }
 
 // getUserAgentList 按useragent类型取列表
 func getUserAgentList(userAgentType UserAgentType) []string {
 	result := make([]string, 0)
 
+	if userAgentType == 0 {
+		return append(result, uas...)
+	}
+
 	if userAgentType == MobileUserAgent {
 		for _, item := range uas {
 			if isMobile(item) {
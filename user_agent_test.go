@@ -2,6 +2,9 @@ package esme
 
 import (
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
 	"testing"
 )
 
@@ -11,3 +14,78 @@ func Test_RandomUserAgent(t *testing.T) {
 		fmt.Println(s)
 	}
 }
+
+func Test_UserAgentPolicy(t *testing.T) {
+	if RandomUserAgent(0) == "" || !isMobile(RandomUserAgent(MobileUserAgent)) {
+		t.Fatal("random user-agent")
+	}
+
+	p := &UserAgentPolicy{Mode: UAWeighted, UserAgents: []string{"a", "b", "c"}, Weights: []int{1, 0, 9}}
+	seen := map[string]int{}
+	for i := 0; i < 1000; i++ {
+		seen[p.Random()]++
+	}
+	if seen["b"] != 0 || seen["c"] < 800 || seen["a"] == 0 {
+		t.Fatalf("seen %v", seen)
+	}
+
+	job := NewJob("ua", 1, NewMemQueue(), JobOptions{UserAgent: &UserAgentPolicy{Mode: UAPerProxy, UserAgents: []string{"a", "b", "c", "d"}}})
+	task := &Task{}
+	for _, proxy := range []string{"http://p1:8080", "http://p2:8080"} {
+		ua := job.userAgent(task, proxy)
+		for i := 0; i < 10; i++ {
+			if got := job.userAgent(task, proxy); got != ua {
+				t.Fatalf("proxy %s: %s != %s", proxy, got, ua)
+			}
+		}
+	}
+}
+
+func Test_JobUserAgent(t *testing.T) {
+	var (
+		mux    sync.Mutex
+		agents = map[string]map[string]bool{}
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.Lock()
+		session := r.URL.Query().Get("session")
+		if agents[session] == nil {
+			agents[session] = map[string]bool{}
+		}
+		agents[session][r.UserAgent()] = true
+		mux.Unlock()
+	}))
+	defer ts.Close()
+
+	queue := NewMemQueue()
+	for i := 0; i < 10; i++ {
+		for _, session := range []string{"1", "2"} {
+			queue.Add(&Task{Url: ts.URL + "/?session=" + session, Method: "GET", Session: session})
+		}
+	}
+	header := http.Header{}
+	header.Set("User-Agent", "explicit")
+	queue.Add(&Task{Url: ts.URL + "/?session=explicit", Method: "GET", Header: &header, Session: "1"})
+
+	var recorded string
+	NewJob("ua", 3, queue, JobOptions{
+		UserAgent: &UserAgentPolicy{Mode: UAPerSession, Type: PCUserAgent},
+		SucceedFunc: func(ctx *Context) {
+			if ctx.Task.Session == "2" {
+				mux.Lock()
+				recorded = ctx.UserAgent()
+				mux.Unlock()
+			}
+		},
+	}).Do()
+	if len(agents["1"]) != 1 || len(agents["2"]) != 1 || !agents["explicit"]["explicit"] || !agents["2"][recorded] || isMobile(recorded) {
+		t.Fatalf("agents %v recorded %s", agents, recorded)
+	}
+
+	// fixed
+	queue.Add(&Task{Url: ts.URL + "/?session=fixed", Method: "GET"})
+	NewJob("ua", 1, queue, JobOptions{UserAgent: &UserAgentPolicy{Mode: UAFixed, UserAgents: []string{"esme/2.0"}}}).Do()
+	if !agents["fixed"]["esme/2.0"] {
+		t.Fatalf("agents %v", agents["fixed"])
+	}
+}
@@ -0,0 +1,355 @@
+/*
+xpath.go
+query html and xml responses with xpath expressions
+*/
+
+package esme
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/antchfx/xpath"
+	"golang.org/x/net/html"
+)
+
+var (
+
+	// xpathCache compiled xpath expressions
+	//	expression string -> *xpath.Expr
+	xpathCache sync.Map
+)
+
+// XPathNode a node selected by an xpath expression
+//	an element, a text node or an attribute
+type XPathNode struct {
+
+	// Node the selected html node
+	//	for an attribute it is the element holding the attribute
+	Node *html.Node
+
+	// attr selected attribute, nil when the node is not an attribute
+	attr *html.Attribute
+}
+
+// Text returns the text of the node
+//	the text of all descendants for an element, the value for an attribute
+func (n *XPathNode) Text() string {
+	if n == nil || n.Node == nil {
+		return ""
+	}
+	if n.attr != nil {
+		return n.attr.Val
+	}
+	return innerText(n.Node)
+}
+
+// Attr returns the attribute value of the node by name
+func (n *XPathNode) Attr(name string) string {
+	if n == nil || n.Node == nil || n.attr != nil {
+		return ""
+	}
+	for _, a := range n.Node.Attr {
+		if a.Key == name {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// IsAttr returns whether the node is an attribute
+func (n *XPathNode) IsAttr() bool {
+	return n != nil && n.attr != nil
+}
+
+// XPath returns the first node matched by expr
+//	returns nil when nothing matches
+//	like: ctx.XPath("//div[@class='title']/a/@href")
+func (c *Context) XPath(expr string) (*XPathNode, error) {
+	nodes, err := c.xpathSelect(expr, true)
+	if err != nil || len(nodes) == 0 {
+		return nil, err
+	}
+	return nodes[0], nil
+}
+
+// XPathAll returns all nodes matched by expr
+func (c *Context) XPathAll(expr string) ([]*XPathNode, error) {
+	return c.xpathSelect(expr, false)
+}
+
+// XPathNodes returns all nodes under top matched by expr
+//	used to query inside a node returned by ctx.XPath
+func XPathNodes(top *html.Node, expr string) ([]*XPathNode, error) {
+	exp, err := compileXPath(expr)
+	if err != nil {
+		return nil, err
+	}
+	return selectXPath(top, exp, false), nil
+}
+
+/*
+private
+*/
+
+func (c *Context) xpathSelect(expr string, first bool) ([]*XPathNode, error) {
+	exp, err := compileXPath(expr)
+	if err != nil {
+		return nil, err
+	}
+	return selectXPath(c.xpathRoot(), exp, first), nil
+}
+
+// xpathRoot returns the root node of the response document
+//	html responses share the document parsed by ctx.HTML()
+func (c *Context) xpathRoot() *html.Node {
+	if c.xmlDoc != nil {
+		return c.xmlDoc
+	}
+	if c.isXML() {
+		root, err := parseXML(c.ToUTF8())
+		if err == nil {
+			c.xmlDoc = root
+			return root
+		}
+	}
+	doc := c.HTML()
+	if len(doc.Nodes) == 0 {
+		return &html.Node{Type: html.DocumentNode}
+	}
+	return doc.Nodes[0]
+}
+
+// isXML returns whether the response is a xml document
+func (c *Context) isXML() bool {
+	ct := strings.ToLower(c.contentType())
+	if strings.Contains(ct, "html") {
+		return false
+	}
+	if strings.Contains(ct, "xml") {
+		return true
+	}
+	return bytes.HasPrefix(bytes.TrimSpace(c.RespBody), []byte("<?xml"))
+}
+
+// compileXPath compile expr and cache it
+func compileXPath(expr string) (*xpath.Expr, error) {
+	if v, ok := xpathCache.Load(expr); ok {
+		return v.(*xpath.Expr), nil
+	}
+	exp, err := xpath.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	xpathCache.Store(expr, exp)
+	return exp, nil
+}
+
+func selectXPath(top *html.Node, exp *xpath.Expr, first bool) []*XPathNode {
+	nodes := make([]*XPathNode, 0)
+	t := exp.Select(&htmlNavigator{root: top, curr: top, attr: -1})
+	for t.MoveNext() {
+		nav := t.Current().(*htmlNavigator)
+		n := &XPathNode{Node: nav.curr}
+		if nav.attr != -1 {
+			n.attr = &nav.curr.Attr[nav.attr]
+		}
+		nodes = append(nodes, n)
+		if first {
+			break
+		}
+	}
+	return nodes
+}
+
+// parseXML parse a xml document into a html.Node tree
+//	element and attribute names keep their case
+func parseXML(b []byte) (*html.Node, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(b))
+	decoder.Strict = false
+	decoder.CharsetReader = func(label string, input io.Reader) (io.Reader, error) {
+		// the body has already been converted to utf-8
+		return input, nil
+	}
+
+	root := &html.Node{Type: html.DocumentNode}
+	curr := root
+	for {
+		tok, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			n := &html.Node{Type: html.ElementNode, Data: t.Name.Local, Namespace: t.Name.Space}
+			for _, a := range t.Attr {
+				n.Attr = append(n.Attr, html.Attribute{Namespace: a.Name.Space, Key: a.Name.Local, Val: a.Value})
+			}
+			curr.AppendChild(n)
+			curr = n
+		case xml.EndElement:
+			if curr.Parent != nil {
+				curr = curr.Parent
+			}
+		case xml.CharData:
+			curr.AppendChild(&html.Node{Type: html.TextNode, Data: string(t)})
+		case xml.Comment:
+			curr.AppendChild(&html.Node{Type: html.CommentNode, Data: string(t)})
+		}
+	}
+	return root, nil
+}
+
+func innerText(n *html.Node) string {
+	if n.Type == html.TextNode || n.Type == html.CommentNode {
+		return n.Data
+	}
+	var buf strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type == html.TextNode {
+				buf.WriteString(child.Data)
+			} else if child.Type == html.ElementNode {
+				walk(child)
+			}
+		}
+	}
+	walk(n)
+	return buf.String()
+}
+
+// htmlNavigator implements xpath.NodeNavigator for html.Node
+type htmlNavigator struct {
+	root, curr *html.Node
+	attr       int
+}
+
+func (h *htmlNavigator) NodeType() xpath.NodeType {
+	switch h.curr.Type {
+	case html.CommentNode:
+		return xpath.CommentNode
+	case html.TextNode:
+		return xpath.TextNode
+	case html.DocumentNode:
+		return xpath.RootNode
+	case html.ElementNode:
+		if h.attr != -1 {
+			return xpath.AttributeNode
+		}
+		return xpath.ElementNode
+	case html.DoctypeNode:
+		// the doctype is treated as a comment so it never matches elements
+		return xpath.CommentNode
+	}
+	return xpath.TextNode
+}
+
+func (h *htmlNavigator) LocalName() string {
+	if h.attr != -1 {
+		return h.curr.Attr[h.attr].Key
+	}
+	return h.curr.Data
+}
+
+func (h *htmlNavigator) Prefix() string {
+	return ""
+}
+
+func (h *htmlNavigator) Value() string {
+	if h.attr != -1 {
+		return h.curr.Attr[h.attr].Val
+	}
+	return innerText(h.curr)
+}
+
+func (h *htmlNavigator) Copy() xpath.NodeNavigator {
+	n := *h
+	return &n
+}
+
+func (h *htmlNavigator) MoveToRoot() {
+	h.curr = h.root
+	h.attr = -1
+}
+
+func (h *htmlNavigator) MoveToParent() bool {
+	if h.attr != -1 {
+		h.attr = -1
+		return true
+	}
+	if h.curr == h.root || h.curr.Parent == nil {
+		return false
+	}
+	h.curr = h.curr.Parent
+	return true
+}
+
+func (h *htmlNavigator) MoveToNextAttribute() bool {
+	if h.attr >= len(h.curr.Attr)-1 {
+		return false
+	}
+	h.attr++
+	return true
+}
+
+func (h *htmlNavigator) MoveToChild() bool {
+	if h.attr != -1 {
+		return false
+	}
+	for node := h.curr.FirstChild; node != nil; node = node.NextSibling {
+		if node.Type != html.DoctypeNode {
+			h.curr = node
+			return true
+		}
+	}
+	return false
+}
+
+func (h *htmlNavigator) MoveToFirst() bool {
+	if h.attr != -1 || h.curr.PrevSibling == nil {
+		return false
+	}
+	node := h.curr
+	for node.PrevSibling != nil {
+		node = node.PrevSibling
+	}
+	h.curr = node
+	return true
+}
+
+func (h *htmlNavigator) MoveToNext() bool {
+	if h.attr != -1 || h.curr == h.root || h.curr.NextSibling == nil {
+		return false
+	}
+	h.curr = h.curr.NextSibling
+	return true
+}
+
+func (h *htmlNavigator) MoveToPrevious() bool {
+	if h.attr != -1 || h.curr == h.root || h.curr.PrevSibling == nil {
+		return false
+	}
+	h.curr = h.curr.PrevSibling
+	return true
+}
+
+func (h *htmlNavigator) MoveTo(other xpath.NodeNavigator) bool {
+	node, ok := other.(*htmlNavigator)
+	if !ok || node.root != h.root {
+		return false
+	}
+	h.curr = node.curr
+	h.attr = node.attr
+	return true
+}
+
+func (h *htmlNavigator) String() string {
+	return h.Value()
+}
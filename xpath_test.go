@@ -0,0 +1,55 @@
+package esme
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_ContextXPath(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/rss" {
+			w.Header().Set("Content-Type", "application/rss+xml")
+			_, _ = w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<rss><channel><item><title>First</title><link>https://a.com/1</link></item>
+<item><title>Second</title><link>https://a.com/2</link></item></channel></rss>`))
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write([]byte(`<html><body><div class="title"><a href="/news/1" id="n1">新闻<b>一</b></a></div>
+<div class="title"><a href="/news/2">新闻二</a></div></body></html>`))
+	}))
+	defer ts.Close()
+
+	ctx := HttpGet(ts.URL)
+	ctx.Do()
+
+	node, err := ctx.XPath("//div[@class='title']/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node.Text() != "新闻一" || node.Attr("id") != "n1" {
+		t.Fatalf("node: got %s %s", node.Text(), node.Attr("id"))
+	}
+	hrefs, err := ctx.XPathAll("//div[@class='title']/a/@href")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hrefs) != 2 || !hrefs[1].IsAttr() || hrefs[1].Text() != "/news/2" {
+		t.Fatalf("hrefs: got %v", hrefs)
+	}
+	inner, _ := XPathNodes(node.Node, ".//b")
+	if len(inner) != 1 || inner[0].Text() != "一" {
+		t.Fatalf("inner: got %v", inner)
+	}
+	if _, err = ctx.XPath("//div[@class="); err == nil {
+		t.Fatal("expected compile error")
+	}
+
+	ctx = HttpGet(ts.URL + "/rss")
+	ctx.Do()
+	titles, _ := ctx.XPathAll("//item/title")
+	if len(titles) != 2 || titles[1].Text() != "Second" {
+		t.Fatalf("titles: got %v", titles)
+	}
+}